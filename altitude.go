@@ -0,0 +1,191 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// *** ALTITUDE STATISTICS *** //
+
+const (
+	// Climbs and sinks smaller than this are noise of the sensor, they don't count for the height gain
+	heightGainNoise = 5 // m
+	// Windows over which the climb and sink rates are averaged, a single fix is too noisy
+	shortClimbWindow = 30 * time.Second
+	longClimbWindow  = time.Minute
+)
+
+// altitudeStats summarises the altitudes of a flight
+// The absolute altitudes come from the GPS, which measures the height above the sea level.
+// The climbs and sinks come from the pressure sensor when the recorder has one: it is
+// far less noisy than the GPS, but its altitude depends on the weather of the day
+type altitudeStats struct {
+	MaxAltitude  int64         // m
+	MinAltitude  int64         // m
+	HeightGain   int64         // m, sum of all the climbs
+	BestClimb30s float64       // m/s, averaged over 30 seconds
+	BestClimb60s float64       // m/s, averaged over 1 minute
+	WorstSink30s float64       // m/s, averaged over 30 seconds, negative
+	ClimbingTime time.Duration // In the air and going up
+	GlidingTime  time.Duration // In the air and going down
+}
+
+// Returns the altitude used for the climbs and sinks: the pressure one, or the GPS one when the recorder has no pressure sensor
+func varioAltitudes(fixes []fix) []float64 {
+	hasPressure := false
+	for _, f := range fixes {
+		if f.PressureAltitude != 0 {
+			hasPressure = true
+			break
+		}
+	}
+
+	altitudes := make([]float64, len(fixes))
+	for i, f := range fixes {
+		if hasPressure {
+			altitudes[i] = float64(f.PressureAltitude)
+		} else {
+			altitudes[i] = float64(f.GPSAltitude)
+		}
+	}
+	return altitudes
+}
+
+// Sums the climbs, ignoring the ones smaller than the noise
+// The altitude has to go down by the noise from a peak before that climb is counted
+func heightGain(altitudes []float64, noise float64) float64 {
+	if len(altitudes) == 0 {
+		return 0
+	}
+
+	gain := 0.0
+	low, high := altitudes[0], altitudes[0]
+	climbing := false
+	for _, alt := range altitudes[1:] {
+		if climbing {
+			if alt > high {
+				high = alt
+			} else if high-alt >= noise {
+				gain += high - low
+				climbing = false
+				low = alt
+			}
+			continue
+		}
+		if alt < low {
+			low = alt
+		} else if alt-low >= noise {
+			climbing = true
+			high = alt
+		}
+	}
+	if climbing {
+		gain += high - low
+	}
+	return gain
+}
+
+// Returns, for every fix, the average vertical speed (m/s) over the window starting at it
+// The fixes less than a window from the end get NaN
+func windowedClimbRates(fixes []fix, altitudes []float64, window time.Duration) []float64 {
+	rates := make([]float64, len(fixes))
+	j := 0
+	for i := range fixes {
+		if j < i {
+			j = i
+		}
+		for j < len(fixes) && fixes[j].Time.Sub(fixes[i].Time) < window {
+			j++
+		}
+		if j == len(fixes) {
+			rates[i] = math.NaN()
+			continue
+		}
+		rates[i] = (altitudes[j] - altitudes[i]) / fixes[j].Time.Sub(fixes[i].Time).Seconds()
+	}
+	return rates
+}
+
+// Returns the highest and the lowest of the rates, ignoring NaN, or 0 when there are none
+func rateExtremes(rates []float64) (best float64, worst float64) {
+	found := false
+	for _, rate := range rates {
+		if math.IsNaN(rate) {
+			continue
+		}
+		if !found || rate > best {
+			best = rate
+		}
+		if !found || rate < worst {
+			worst = rate
+		}
+		found = true
+	}
+	return best, worst
+}
+
+// Computes the altitude statistics of the fixes of a flight
+func computeAltitudeStats(fixes []fix) altitudeStats {
+	stats := altitudeStats{}
+	if len(fixes) == 0 {
+		return stats
+	}
+
+	stats.MaxAltitude, stats.MinAltitude = fixes[0].altitude(), fixes[0].altitude()
+	for _, f := range fixes {
+		if alt := f.altitude(); alt > stats.MaxAltitude {
+			stats.MaxAltitude = alt
+		} else if alt < stats.MinAltitude {
+			stats.MinAltitude = alt
+		}
+	}
+
+	altitudes := varioAltitudes(fixes)
+	stats.HeightGain = int64(math.Round(heightGain(altitudes, heightGainNoise)))
+
+	short := windowedClimbRates(fixes, altitudes, shortClimbWindow)
+	stats.BestClimb30s, stats.WorstSink30s = rateExtremes(short)
+	stats.BestClimb60s, _ = rateExtremes(windowedClimbRates(fixes, altitudes, longClimbWindow))
+
+	// Every segment is climbing or gliding depending on the averaged rate where it starts,
+	// the last 30 seconds use the last rate known
+	last := 0.0
+	for i := 1; i < len(fixes); i++ {
+		if !math.IsNaN(short[i-1]) {
+			last = short[i-1]
+		}
+		if last > 0 {
+			stats.ClimbingTime += fixes[i].Time.Sub(fixes[i-1].Time)
+		} else {
+			stats.GlidingTime += fixes[i].Time.Sub(fixes[i-1].Time)
+		}
+	}
+
+	// Rounded to the centimeter per second, the sensors are not more precise
+	stats.BestClimb30s = math.Round(stats.BestClimb30s*100) / 100
+	stats.BestClimb60s = math.Round(stats.BestClimb60s*100) / 100
+	stats.WorstSink30s = math.Round(stats.WorstSink30s*100) / 100
+	return stats
+}
+
+// apiField is a value of the track as it is named and formatted in the API
+type apiField struct {
+	Name  string
+	Value string
+}
+
+// Returns the statistics in the order of the track detail, they are also the fields of GET /api/track/<id>/<field>
+func (s altitudeStats) apiFields() []apiField {
+	formatRate := func(rate float64) string { return strconv.FormatFloat(rate, 'f', 2, 64) }
+	return []apiField{
+		{"max_altitude", strconv.FormatInt(s.MaxAltitude, 10)},
+		{"min_altitude", strconv.FormatInt(s.MinAltitude, 10)},
+		{"height_gain", strconv.FormatInt(s.HeightGain, 10)},
+		{"best_climb_30s", formatRate(s.BestClimb30s)},
+		{"best_climb_60s", formatRate(s.BestClimb60s)},
+		{"worst_sink_30s", formatRate(s.WorstSink30s)},
+		{"climbing_time", formatSeconds(s.ClimbingTime)},
+		{"gliding_time", formatSeconds(s.GlidingTime)},
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	igc "github.com/marni/goigc"
+)
+
+func Test_heightGain(t *testing.T) {
+	// The small bumps are noise, only the climb from 1000 to 1020 counts
+	gain := heightGain([]float64{1000, 1003, 1001, 1004, 1000, 1020, 1010}, 5)
+	if gain != 20 {
+		t.Errorf("Expected a gain of 20 m, got %f", gain)
+	}
+}
+
+func Test_computeAltitudeStats(t *testing.T) {
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1) // Gliding down to 900 m
+	fixes = moveNorth(fixes, 5*time.Minute, 5, 3)   // Thermalling up to 1800 m
+	fixes = moveNorth(fixes, 5*time.Minute, 35, -2) // Gliding down to 1200 m
+
+	stats := computeAltitudeStats(fixes)
+
+	if stats.MaxAltitude != 1800 || stats.MinAltitude != 900 {
+		t.Errorf("Expected altitudes between 900 and 1800 m, got %d - %d", stats.MinAltitude, stats.MaxAltitude)
+	}
+	if stats.HeightGain != 900 {
+		t.Errorf("Expected a height gain of 900 m, got %d", stats.HeightGain)
+	}
+	if stats.BestClimb30s != 3 || stats.BestClimb60s != 3 || stats.WorstSink30s != -2 {
+		t.Errorf("Expected climbs of 3 m/s and a sink of -2 m/s, got %f %f %f", stats.BestClimb30s, stats.BestClimb60s, stats.WorstSink30s)
+	}
+
+	// The averaged rate changes sign a few seconds away from the bottom and the top of the thermal
+	if math.Abs((stats.ClimbingTime - 5*time.Minute).Seconds()) > 30 {
+		t.Errorf("Expected about 5 minutes climbing, got %s", stats.ClimbingTime)
+	}
+	if stats.ClimbingTime+stats.GlidingTime != 20*time.Minute {
+		t.Errorf("Expected the climbing and gliding times to add up to the flight, got %s and %s", stats.ClimbingTime, stats.GlidingTime)
+	}
+}
+
+func Test_computeAltitudeStats_Pressure(t *testing.T) {
+	// The GPS altitude jumps around, the pressure altitude is steady
+	fixes := moveNorth(nil, 5*time.Minute, 30, 0)
+	for i := range fixes {
+		fixes[i].PressureAltitude = 1400
+		if i%2 == 1 {
+			fixes[i].GPSAltitude += 30
+		}
+	}
+
+	stats := computeAltitudeStats(fixes)
+	if stats.HeightGain != 0 || stats.BestClimb30s != 0 || stats.ClimbingTime != 0 {
+		t.Errorf("Expected no climb with a steady pressure altitude, got %+v", stats)
+	}
+	if stats.MaxAltitude != 1530 {
+		t.Errorf("Expected the GPS altitude for the maximum, got %d", stats.MaxAltitude)
+	}
+}
+
+func Test_computeAltitudeStats_Empty(t *testing.T) {
+	if stats := computeAltitudeStats(nil); stats != (altitudeStats{}) {
+		t.Errorf("Expected no statistics without fixes, got %+v", stats)
+	}
+	// Shorter than the windows, there is no averaged rate
+	stats := computeAltitudeStats(moveNorth(nil, 10*time.Second, 30, 2))
+	if stats.BestClimb30s != 0 || stats.HeightGain != 20 {
+		t.Errorf("Unexpected statistics for a short track %+v", stats)
+	}
+}
+
+func Test_analyseTrack_SampleAltitude(t *testing.T) {
+	parsed, err := igc.Parse(string(readSampleIGC(t)))
+	if err != nil {
+		t.Fatalf("Error parsing the sample file, %s", err)
+	}
+
+	track := tracks{}
+	analyseTrack(&track, trackFixes(parsed))
+
+	stats := track.Altitude
+	if stats.MaxAltitude <= stats.MinAltitude || stats.HeightGain <= 0 {
+		t.Errorf("Unexpected altitudes %+v", stats)
+	}
+	if stats.BestClimb30s <= 0 || stats.BestClimb60s > stats.BestClimb30s || stats.WorstSink30s >= 0 {
+		t.Errorf("Unexpected climb and sink rates %+v", stats)
+	}
+	if stats.ClimbingTime+stats.GlidingTime != track.Duration {
+		t.Errorf("Expected the climbing and gliding times to add up to the flight %s, got %+v", track.Duration, stats)
+	}
+}
+
+func Test_handlerField_Altitude(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+	track, _, _ := tracksDB.TrackByID(context.Background(), "1")
+
+	router := newRouter()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1", nil))
+	detail := rec.Body.String()
+
+	for _, field := range track.Altitude.apiFields() {
+		if !strings.Contains(detail, "\""+field.Name+"\":\""+field.Value+"\"") {
+			t.Errorf("Expected %s in the track, got %s", field.Name, detail)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/"+field.Name, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != field.Value {
+			t.Errorf("%s: expected %q, got %d %q", field.Name, field.Value, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -304,22 +306,22 @@ func Test_handlerTrack_Post_Empty(t *testing.T) {
 
 }
 
-/////Mongo tests
+/////Store tests
 func Test_mongoConnect(t *testing.T) {
-	if conn := mongoConnect(); conn == nil {
+	if conn, err := mongoConnect(StorageConfig{URI: "mongodb://localhost:27017", MaxPoolSize: 1, QueryTimeout: time.Second}); conn == nil || err != nil {
 		t.Error("No connection")
 	}
 }
 
 func Test_urlInMong(t *testing.T) {
-	urlExists := urlInMongo(`Some random URL`, mongoConnect().Database("igcfiles").Collection("tracks"))
+	_, urlExists, _ := tracksDB.TrackByURL(context.Background(), `Some random URL`)
 	if urlExists {
 		t.Error("Track should not exist")
 	}
 }
 
 func Test_getAllTrack(t *testing.T) {
-	allTracks := getAllTracks(mongoConnect())
+	allTracks, _ := tracksDB.AllTracks(context.Background())
 
 	if len(allTracks) < 0 {
 		t.Error("It should be bigger")
@@ -327,7 +329,7 @@ func Test_getAllTrack(t *testing.T) {
 }
 
 func Test_getAllWebhooks(t *testing.T) {
-	allWebhooks := getAllWebhooks(mongoConnect())
+	allWebhooks, _ := webhooksDB.AllWebhooks(context.Background())
 
 	if len(allWebhooks) < 0 {
 		t.Error("It should be bigger")
@@ -335,7 +337,7 @@ func Test_getAllWebhooks(t *testing.T) {
 }
 
 func Test_getTrack(t *testing.T) {
-	track := getTrack(mongoConnect(), `url`)
+	track, _, _ := tracksDB.TrackByURL(context.Background(), `url`)
 
 	if track.URL != "" {
 		t.Error("It should be empty")
@@ -343,5 +345,5 @@ func Test_getTrack(t *testing.T) {
 }
 
 func Test_deleteWebhook(t *testing.T) {
-	deleteWebhook(mongoConnect(), `noWebhook`)
+	webhooksDB.DeleteWebhook(context.Background(), `noWebhook`)
 }
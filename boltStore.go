@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket names used by the BoltDB store
+var (
+	bucketMeta          = []byte("meta")
+	bucketTracks        = []byte("tracks")
+	bucketTracksByID    = []byte("tracks_by_id")
+	bucketTracksByURL   = []byte("tracks_by_url")
+	bucketTracksByHash  = []byte("tracks_by_hash")
+	bucketTrackData     = []byte("track_data")
+	bucketWebhooks      = []byte("webhooks")
+	bucketWebhooksByID  = []byte("webhooks_by_id")
+	bucketWebhooksByURL = []byte("webhooks_by_url")
+	bucketSequences     = []byte("sequences")
+	keySchemaVersion    = []byte("schema_version")
+)
+
+// How long to wait for the lock on the database file, another process might have it open
+const boltOpenTimeout = 5 * time.Second
+
+var errBoltBucketMissing = errors.New("bolt bucket is missing, the database was not migrated")
+
+// boltMigrations are run in order when the store is opened
+// The index of the last migration that ran is kept in the meta bucket, so every migration only runs once
+// New migrations must always be appended at the end
+var boltMigrations = []func(tx *bolt.Tx) error{
+	// 1: buckets for tracks, webhooks and their lookup indexes
+	func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketTracks, bucketTracksByID, bucketTracksByURL,
+			bucketWebhooks, bucketWebhooksByID, bucketWebhooksByURL} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 2: ID sequences, starting after the IDs already stored
+	func(tx *bolt.Tx) error {
+		sequences, err := tx.CreateBucketIfNotExists(bucketSequences)
+		if err != nil {
+			return err
+		}
+		for sequence, index := range map[string][]byte{sequenceTracks: bucketTracksByID, sequenceWebhooks: bucketWebhooksByID} {
+			var ids []string
+			err := tx.Bucket(index).ForEach(func(k, v []byte) error {
+				ids = append(ids, string(k))
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if err := sequences.Put([]byte(sequence), itob(uint64(maxNumericID(ids)))); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// 3: index of the tracks by the hash of their IGC file, tracks stored before have no hash
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketTracksByHash)
+		return err
+	},
+	// 4: IGC files and fixes of the tracks, keyed by the track ID
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketTrackData)
+		return err
+	},
+}
+
+// boltStore is a file backed implementation of TrackStore and WebhookStore
+// It is meant for single node deployments where running a database server is overkill
+//
+// Tracks and webhooks are stored as JSON under a sequence key, so iterating a bucket
+// returns them in the order they were added. The *_by_id and *_by_url buckets map
+// the ID or URL to that sequence key.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// Opens (or creates) the database file and runs the pending migrations
+func newBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+
+	store := &boltStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Runs every migration newer than the schema version stored in the database
+func (s *boltStore) migrate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+
+		version := 0
+		if v := meta.Get(keySchemaVersion); v != nil {
+			version = int(binary.BigEndian.Uint64(v))
+		}
+		if version > len(boltMigrations) {
+			return fmt.Errorf("database schema version %d is newer than this binary supports (%d)", version, len(boltMigrations))
+		}
+
+		for ; version < len(boltMigrations); version++ {
+			if err := boltMigrations[version](tx); err != nil {
+				return fmt.Errorf("migration %d: %s", version+1, err)
+			}
+		}
+
+		return meta.Put(keySchemaVersion, itob(uint64(version)))
+	})
+}
+
+// Close closes the database file
+func (s *boltStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+// NextID increments the named sequence and returns the new value
+// Bolt only allows one writer at a time, so the increment is atomic
+func (s *boltStore) NextID(ctx context.Context, sequence string) (int64, error) {
+	var id uint64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketSequences)
+		if err != nil {
+			return err
+		}
+		if v := b[0].Get([]byte(sequence)); v != nil {
+			id = binary.BigEndian.Uint64(v)
+		}
+		id++
+		return b[0].Put([]byte(sequence), itob(id))
+	})
+	return int64(id), err
+}
+
+// Converts a sequence number to a key that sorts in insertion order
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+// Returns the buckets with the given names, or an error if one of them doesn't exist
+func buckets(tx *bolt.Tx, names ...[]byte) ([]*bolt.Bucket, error) {
+	res := make([]*bolt.Bucket, 0, len(names))
+	for _, name := range names {
+		b := tx.Bucket(name)
+		if b == nil {
+			return nil, errBoltBucketMissing
+		}
+		res = append(res, b)
+	}
+	return res, nil
+}
+
+// boltIndex is a secondary lookup key of a record, like its URL
+type boltIndex struct {
+	bucket []byte
+	key    string
+}
+
+// Stores value under a new sequence key in data, and indexes it by id and the other indexes
+func boltInsert(tx *bolt.Tx, data, byID []byte, id string, value interface{}, indexes ...boltIndex) error {
+	b, err := buckets(tx, data, byID)
+	if err != nil {
+		return err
+	}
+
+	if b[1].Get([]byte(id)) != nil {
+		return errDuplicateID
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	seq, err := b[0].NextSequence()
+	if err != nil {
+		return err
+	}
+	key := itob(seq)
+
+	if err := b[0].Put(key, encoded); err != nil {
+		return err
+	}
+	if err := b[1].Put([]byte(id), key); err != nil {
+		return err
+	}
+
+	for _, index := range indexes {
+		// Bolt doesn't allow empty keys, records without a URL are just not indexed by it
+		if index.key == "" {
+			continue
+		}
+		bucket, err := buckets(tx, index.bucket)
+		if err != nil {
+			return err
+		}
+		if err := bucket[0].Put([]byte(index.key), key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Removes the index entries that point to the record with the given sequence key
+// The entries pointing to another record are kept, eg. the hash of the flights split from the same file
+func boltUnindex(tx *bolt.Tx, key []byte, indexes ...boltIndex) error {
+	for _, index := range indexes {
+		bucket, err := buckets(tx, index.bucket)
+		if err != nil {
+			return err
+		}
+		if index.key == "" || string(bucket[0].Get([]byte(index.key))) != string(key) {
+			continue
+		}
+		if err := bucket[0].Delete([]byte(index.key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Decodes into value the record that the index bucket points to for the given key
+func boltLookup(tx *bolt.Tx, data, index []byte, key string, value interface{}) (bool, error) {
+	b, err := buckets(tx, data, index)
+	if err != nil {
+		return false, err
+	}
+
+	if key == "" {
+		return false, nil
+	}
+
+	seq := b[1].Get([]byte(key))
+	if seq == nil {
+		return false, nil
+	}
+	encoded := b[0].Get(seq)
+	if encoded == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(encoded, value)
+}
+
+// InsertTrack stores a new track
+func (s *boltStore) InsertTrack(ctx context.Context, track tracks) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltInsert(tx, bucketTracks, bucketTracksByID, track.UniqueID, track,
+			boltIndex{bucketTracksByURL, track.URL},
+			boltIndex{bucketTracksByHash, track.ContentHash},
+		)
+	})
+}
+
+// UpdateTrack replaces the track with the same ID, and indexes it by its new URL and hash instead of the old ones
+func (s *boltStore) UpdateTrack(ctx context.Context, track tracks) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketTracks, bucketTracksByID, bucketTracksByURL, bucketTracksByHash)
+		if err != nil {
+			return err
+		}
+
+		key := b[1].Get([]byte(track.UniqueID))
+		if key == nil {
+			return nil
+		}
+
+		stored := tracks{}
+		if err := json.Unmarshal(b[0].Get(key), &stored); err != nil {
+			return err
+		}
+		// The old URL and hash must not find the track anymore
+		if err := boltUnindex(tx, key, boltIndex{bucketTracksByURL, stored.URL}, boltIndex{bucketTracksByHash, stored.ContentHash}); err != nil {
+			return err
+		}
+
+		encoded, err := json.Marshal(track)
+		if err != nil {
+			return err
+		}
+		if err := b[0].Put(key, encoded); err != nil {
+			return err
+		}
+
+		for _, index := range []boltIndex{{bucketTracksByURL, track.URL}, {bucketTracksByHash, track.ContentHash}} {
+			if index.key == "" {
+				continue
+			}
+			if err := tx.Bucket(index.bucket).Put([]byte(index.key), key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteTrack removes the track with the given ID, its index entries and its data
+func (s *boltStore) DeleteTrack(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketTracks, bucketTracksByID, bucketTrackData)
+		if err != nil {
+			return err
+		}
+		if err := b[2].Delete([]byte(id)); err != nil {
+			return err
+		}
+
+		key := b[1].Get([]byte(id))
+		if key == nil {
+			return nil
+		}
+
+		track := tracks{}
+		if err := json.Unmarshal(b[0].Get(key), &track); err != nil {
+			return err
+		}
+		if err := boltUnindex(tx, key, boltIndex{bucketTracksByURL, track.URL}, boltIndex{bucketTracksByHash, track.ContentHash}); err != nil {
+			return err
+		}
+		if err := b[0].Delete(key); err != nil {
+			return err
+		}
+		return b[1].Delete([]byte(id))
+	})
+}
+
+// TrackByID returns the track with the given ID
+func (s *boltStore) TrackByID(ctx context.Context, id string) (tracks, bool, error) {
+	track := tracks{}
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltLookup(tx, bucketTracks, bucketTracksByID, id, &track)
+		return err
+	})
+	return track, found, err
+}
+
+// TrackByURL returns the track registered with the given URL
+func (s *boltStore) TrackByURL(ctx context.Context, url string) (tracks, bool, error) {
+	track := tracks{}
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltLookup(tx, bucketTracks, bucketTracksByURL, url, &track)
+		return err
+	})
+	return track, found, err
+}
+
+// TrackByHash returns the track whose IGC file has the given content hash
+func (s *boltStore) TrackByHash(ctx context.Context, hash string) (tracks, bool, error) {
+	track := tracks{}
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltLookup(tx, bucketTracks, bucketTracksByHash, hash, &track)
+		return err
+	})
+	return track, found, err
+}
+
+// AllTracks returns every track, in the order they were added
+func (s *boltStore) AllTracks(ctx context.Context) ([]tracks, error) {
+	resTracks := []tracks{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketTracks)
+		if err != nil {
+			return err
+		}
+		return b[0].ForEach(func(k, v []byte) error {
+			track := tracks{}
+			if err := json.Unmarshal(v, &track); err != nil {
+				return err
+			}
+			resTracks = append(resTracks, track)
+			return nil
+		})
+	})
+	return resTracks, err
+}
+
+// TrackIDs returns the IDs of every track, in the order they were added
+func (s *boltStore) TrackIDs(ctx context.Context) ([]string, error) {
+	resTracks, err := s.AllTracks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(resTracks))
+	for _, val := range resTracks {
+		ids = append(ids, val.UniqueID)
+	}
+	return ids, nil
+}
+
+// CountTracks returns the number of stored tracks
+func (s *boltStore) CountTracks(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketTracks)
+		if err != nil {
+			return err
+		}
+		count = int64(b[0].Stats().KeyN)
+		return nil
+	})
+	return count, err
+}
+
+// DeleteAllTracks removes every track by recreating the track buckets
+func (s *boltStore) DeleteAllTracks(ctx context.Context) (int64, error) {
+	var count int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketTracks)
+		if err != nil {
+			return err
+		}
+		count = int64(b[0].Stats().KeyN)
+
+		for _, name := range [][]byte{bucketTracks, bucketTracksByID, bucketTracksByURL, bucketTracksByHash, bucketTrackData} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return count, err
+}
+
+// SaveTrackData stores the IGC file and the fixes of a track
+func (s *boltStore) SaveTrackData(ctx context.Context, data trackData) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketTrackData)
+		if err != nil {
+			return err
+		}
+		return b[0].Put([]byte(data.UniqueID), encoded)
+	})
+}
+
+// TrackData returns the IGC file and the fixes of the track with the given ID
+func (s *boltStore) TrackData(ctx context.Context, id string) (trackData, bool, error) {
+	data := trackData{}
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketTrackData)
+		if err != nil {
+			return err
+		}
+		encoded := b[0].Get([]byte(id))
+		if encoded == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(encoded, &data)
+	})
+	return data, found, err
+}
+
+// InsertWebhook stores a new webhook
+func (s *boltStore) InsertWebhook(ctx context.Context, webhook Webhook) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return boltInsert(tx, bucketWebhooks, bucketWebhooksByID, webhook.WebhookID, webhook,
+			boltIndex{bucketWebhooksByURL, webhook.WebhookURL},
+		)
+	})
+}
+
+// WebhookByID returns the webhook with the given ID
+func (s *boltStore) WebhookByID(ctx context.Context, id string) (Webhook, bool, error) {
+	webhook := Webhook{}
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltLookup(tx, bucketWebhooks, bucketWebhooksByID, id, &webhook)
+		return err
+	})
+	return webhook, found, err
+}
+
+// WebhookByURL returns the webhook registered for the given URL
+func (s *boltStore) WebhookByURL(ctx context.Context, url string) (Webhook, bool, error) {
+	webhook := Webhook{}
+	var found bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		found, err = boltLookup(tx, bucketWebhooks, bucketWebhooksByURL, url, &webhook)
+		return err
+	})
+	return webhook, found, err
+}
+
+// UpdateWebhookTrigger changes the minTriggerValue of the webhook with the given URL
+func (s *boltStore) UpdateWebhookTrigger(ctx context.Context, url string, minTriggerValue int32) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketWebhooks, bucketWebhooksByURL)
+		if err != nil {
+			return err
+		}
+
+		key := b[1].Get([]byte(url))
+		if key == nil {
+			return nil
+		}
+
+		webhook := Webhook{}
+		if err := json.Unmarshal(b[0].Get(key), &webhook); err != nil {
+			return err
+		}
+		webhook.MinTriggerValue = minTriggerValue
+
+		encoded, err := json.Marshal(webhook)
+		if err != nil {
+			return err
+		}
+		return b[0].Put(key, encoded)
+	})
+}
+
+// DeleteWebhook removes the webhook with the given ID
+func (s *boltStore) DeleteWebhook(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketWebhooks, bucketWebhooksByID, bucketWebhooksByURL)
+		if err != nil {
+			return err
+		}
+
+		key := b[1].Get([]byte(id))
+		if key == nil {
+			return nil
+		}
+
+		webhook := Webhook{}
+		if err := json.Unmarshal(b[0].Get(key), &webhook); err != nil {
+			return err
+		}
+
+		if err := b[0].Delete(key); err != nil {
+			return err
+		}
+		if err := b[1].Delete([]byte(id)); err != nil {
+			return err
+		}
+		return b[2].Delete([]byte(webhook.WebhookURL))
+	})
+}
+
+// AllWebhooks returns every registered webhook
+func (s *boltStore) AllWebhooks(ctx context.Context) ([]Webhook, error) {
+	resWebhooks := []Webhook{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b, err := buckets(tx, bucketWebhooks)
+		if err != nil {
+			return err
+		}
+		return b[0].ForEach(func(k, v []byte) error {
+			webhook := Webhook{}
+			if err := json.Unmarshal(v, &webhook); err != nil {
+				return err
+			}
+			resWebhooks = append(resWebhooks, webhook)
+			return nil
+		})
+	})
+	return resWebhooks, err
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func Test_boltStore(t *testing.T) {
+	dir := t.TempDir()
+
+	trackStore, err := newBoltStore(filepath.Join(dir, "tracks.db"))
+	if err != nil {
+		t.Fatalf("Error opening the bolt store, %s", err)
+	}
+	defer trackStore.Close(context.Background())
+	testTrackStore(t, trackStore)
+
+	webhookStore, err := newBoltStore(filepath.Join(dir, "webhooks.db"))
+	if err != nil {
+		t.Fatalf("Error opening the bolt store, %s", err)
+	}
+	defer webhookStore.Close(context.Background())
+	testWebhookStore(t, webhookStore)
+}
+
+func Test_boltStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paragliding.db")
+
+	store, err := newBoltStore(path)
+	if err != nil {
+		t.Fatalf("Error opening the bolt store, %s", err)
+	}
+	store.InsertTrack(context.Background(), tracks{UniqueID: "7", URL: "http://example.com/seven.igc"})
+	store.Close(context.Background())
+
+	// Opening it again must not run the migrations twice or lose the data
+	store, err = newBoltStore(path)
+	if err != nil {
+		t.Fatalf("Error reopening the bolt store, %s", err)
+	}
+	defer store.Close(context.Background())
+
+	track, found, err := store.TrackByURL(context.Background(), "http://example.com/seven.igc")
+	if err != nil || !found || track.UniqueID != "7" {
+		t.Errorf("Expected to find track 7 after reopening, got %v %v %v", track, found, err)
+	}
+
+	if err := store.InsertTrack(context.Background(), tracks{UniqueID: "7"}); err != errDuplicateID {
+		t.Errorf("Expected errDuplicateID, got %v", err)
+	}
+}
+
+func Test_boltStore_NextID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "paragliding.db")
+
+	store, err := newBoltStore(path)
+	if err != nil {
+		t.Fatalf("Error opening the bolt store, %s", err)
+	}
+	testIDAllocator(t, store)
+	store.Close(context.Background())
+
+	// The sequence continues after reopening the file
+	store, err = newBoltStore(path)
+	if err != nil {
+		t.Fatalf("Error reopening the bolt store, %s", err)
+	}
+	defer store.Close(context.Background())
+
+	if id, _ := store.NextID(context.Background(), sequenceTracks); id != 201 {
+		t.Errorf("Expected the next track ID to be 201, got %d", id)
+	}
+}
@@ -0,0 +1,466 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// *** CONFIGURATION *** //
+
+// Config holds every setting of the service
+// The values are read, in order of precedence, from the command line flags, the environment
+// variables, the optional YAML file and finally the defaults in defaultConfig()
+type Config struct {
+	Listen          string           `yaml:"listen"`
+	ShutdownTimeout time.Duration    `yaml:"shutdown_timeout"` // How long to wait for in-flight requests and webhooks when stopping
+	Storage         StorageConfig    `yaml:"storage"`
+	Ticker          TickerConfig     `yaml:"ticker"`
+	Webhook         WebhookConfig    `yaml:"webhook"`
+	Admin           AdminConfig      `yaml:"admin"`
+	Upload          UploadConfig     `yaml:"upload"`
+	Jobs            JobsConfig       `yaml:"jobs"`
+	Fetch           FetchConfig      `yaml:"fetch"`
+	Scoring         ScoringConfig    `yaml:"scoring"`
+	Task            TaskConfig       `yaml:"task"`
+	Validation      ValidationConfig `yaml:"validation"`
+}
+
+// StorageConfig selects and configures the storage backend
+type StorageConfig struct {
+	Backend             string        `yaml:"backend"` // memory, bolt or mongo
+	URI                 string        `yaml:"uri"`     // MongoDB connection string
+	Database            string        `yaml:"database"`
+	TracksCollection    string        `yaml:"tracks_collection"`
+	WebhooksCollection  string        `yaml:"webhooks_collection"`
+	CountersCollection  string        `yaml:"counters_collection"`   // Keeps the last track and webhook IDs
+	TrackDataCollection string        `yaml:"track_data_collection"` // Keeps the IGC files and the fixes
+	MaxPoolSize         int           `yaml:"max_pool_size"`         // Maximum number of connections to MongoDB
+	QueryTimeout        time.Duration `yaml:"query_timeout"`         // Applied to every database query
+	Path                string        `yaml:"path"`                  // BoltDB file
+}
+
+// TickerConfig holds the settings of the ticker API
+type TickerConfig struct {
+	PageSize int `yaml:"page_size"` // How many track ids a ticker response contains
+}
+
+// WebhookConfig holds the settings used when invoking the registered webhooks
+type WebhookConfig struct {
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// UploadConfig holds the limits for IGC files sent to the API
+type UploadConfig struct {
+	MaxSize      int64 `yaml:"max_size"`       // In bytes
+	MaxBatchSize int64 `yaml:"max_batch_size"` // In bytes, for the whole body of a batch import
+}
+
+// FetchConfig holds the limits for downloading the IGC files of the posted URLs
+type FetchConfig struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	MaxRedirects int           `yaml:"max_redirects"`
+	Schemes      []string      `yaml:"schemes"`
+	AllowPrivate bool          `yaml:"allow_private"` // Allows the internal addresses, only for development
+}
+
+// ScoringConfig holds the multipliers of the cross-country score, the points are the km times the multiplier
+type ScoringConfig struct {
+	FreeDistance float64 `yaml:"free_distance"`
+	FlatTriangle float64 `yaml:"flat_triangle"`
+	FAITriangle  float64 `yaml:"fai_triangle"`
+	ClosingRatio float64 `yaml:"closing_ratio"` // Longest distance between the start and the finish of a triangle, as a part of its perimeter
+}
+
+// TaskConfig holds the settings of the declared task analysis
+type TaskConfig struct {
+	CylinderRadius float64 `yaml:"cylinder_radius"` // m, around the start, turnpoints and finish
+}
+
+// ValidationConfig decides what happens to the IGC files by the status of their security record,
+// one of unsigned, unverified or invalid
+type ValidationConfig struct {
+	Reject []string `yaml:"reject"` // The files with these statuses are refused when they are registered
+	Hide   []string `yaml:"hide"`   // The tracks with these statuses are left out of GET /api/track
+}
+
+// JobsConfig holds the settings of the background ingestion queue
+type JobsConfig struct {
+	Workers   int           `yaml:"workers"`    // Number of files fetched and parsed at the same time
+	QueueSize int           `yaml:"queue_size"` // Jobs waiting for a worker, more are refused
+	Timeout   time.Duration `yaml:"timeout"`    // For a single job
+	Retention time.Duration `yaml:"retention"`  // How long the finished jobs can still be read
+}
+
+// AdminConfig holds the credentials for the admin API
+// When they are empty the admin API is not protected
+type AdminConfig struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// The configuration used by the handlers, main() replaces it with the loaded one
+var config = defaultConfig()
+
+// Returns the configuration used when nothing else is set
+func defaultConfig() Config {
+	return Config{
+		Listen:          ":8080",
+		ShutdownTimeout: 15 * time.Second,
+		Storage: StorageConfig{
+			Backend:             "mongo",
+			Database:            "igcfiles",
+			TracksCollection:    "tracks",
+			WebhooksCollection:  "webhooks",
+			CountersCollection:  "counters",
+			TrackDataCollection: "trackdata",
+			MaxPoolSize:         20,
+			QueryTimeout:        5 * time.Second,
+			Path:                "paragliding.db",
+		},
+		Ticker: TickerConfig{
+			PageSize: 5,
+		},
+		Webhook: WebhookConfig{
+			Timeout: 10 * time.Second,
+		},
+		Upload: UploadConfig{
+			MaxSize:      10 << 20,
+			MaxBatchSize: 200 << 20,
+		},
+		Fetch: FetchConfig{
+			Timeout:      20 * time.Second,
+			MaxRedirects: 5,
+			Schemes:      []string{"http", "https"},
+		},
+		Jobs: JobsConfig{
+			Workers:   4,
+			QueueSize: 100,
+			Timeout:   time.Minute,
+			Retention: time.Hour,
+		},
+		Scoring: ScoringConfig{
+			FreeDistance: 1.0,
+			FlatTriangle: 1.2,
+			FAITriangle:  1.4,
+			ClosingRatio: 0.2,
+		},
+		Task: TaskConfig{
+			CylinderRadius: 400,
+		},
+	}
+}
+
+// Loads the configuration from the file, the environment and the command line arguments
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	fs := flag.NewFlagSet("paragliding", flag.ContinueOnError)
+	configFile := fs.String("config", "", "path of the YAML configuration file (env CONFIG_FILE)")
+	listen := fs.String("listen", "", "address to listen on, eg: :8080 (env LISTEN_ADDR or PORT)")
+	backend := fs.String("store", "", "storage backend: memory, bolt or mongo (env STORE)")
+	uri := fs.String("mongo-uri", "", "MongoDB connection string (env MONGODB_URI)")
+	database := fs.String("database", "", "MongoDB database name (env MONGODB_DATABASE)")
+	poolSize := fs.Int("mongo-pool-size", 0, "maximum number of connections to MongoDB (env MONGODB_MAX_POOL_SIZE)")
+	queryTimeout := fs.Duration("query-timeout", 0, "timeout of every database query (env QUERY_TIMEOUT)")
+	boltPath := fs.String("bolt-path", "", "BoltDB file (env BOLT_PATH)")
+	pageSize := fs.Int("ticker-page-size", 0, "number of track ids in a ticker response (env TICKER_PAGE_SIZE)")
+	webhookTimeout := fs.Duration("webhook-timeout", 0, "timeout when invoking a webhook (env WEBHOOK_TIMEOUT)")
+	adminUser := fs.String("admin-user", "", "username for the admin API (env ADMIN_USER)")
+	adminPassword := fs.String("admin-password", "", "password for the admin API (env ADMIN_PASSWORD)")
+	uploadMaxSize := fs.Int64("upload-max-size", 0, "maximum size in bytes of an IGC file (env UPLOAD_MAX_SIZE)")
+	uploadMaxBatchSize := fs.Int64("upload-max-batch-size", 0, "maximum size in bytes of a batch import (env UPLOAD_MAX_BATCH_SIZE)")
+	jobWorkers := fs.Int("job-workers", 0, "number of background ingestion workers (env JOB_WORKERS)")
+	jobQueueSize := fs.Int("job-queue-size", 0, "maximum number of queued ingestion jobs (env JOB_QUEUE_SIZE)")
+	fetchTimeout := fs.Duration("fetch-timeout", 0, "timeout when downloading the IGC file of a URL (env FETCH_TIMEOUT)")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long to wait for in-flight requests when stopping (env SHUTDOWN_TIMEOUT)")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	// The YAML file
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("reading the config file: %s", err)
+		}
+		if err := yaml.UnmarshalStrict(content, &cfg); err != nil {
+			return cfg, fmt.Errorf("parsing the config file %s: %s", path, err)
+		}
+	}
+
+	// The environment variables
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	// The command line flags, only the ones that were set
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "store":
+			cfg.Storage.Backend = *backend
+		case "mongo-uri":
+			cfg.Storage.URI = *uri
+		case "database":
+			cfg.Storage.Database = *database
+		case "mongo-pool-size":
+			cfg.Storage.MaxPoolSize = *poolSize
+		case "query-timeout":
+			cfg.Storage.QueryTimeout = *queryTimeout
+		case "bolt-path":
+			cfg.Storage.Path = *boltPath
+		case "ticker-page-size":
+			cfg.Ticker.PageSize = *pageSize
+		case "webhook-timeout":
+			cfg.Webhook.Timeout = *webhookTimeout
+		case "admin-user":
+			cfg.Admin.Username = *adminUser
+		case "admin-password":
+			cfg.Admin.Password = *adminPassword
+		case "upload-max-size":
+			cfg.Upload.MaxSize = *uploadMaxSize
+		case "upload-max-batch-size":
+			cfg.Upload.MaxBatchSize = *uploadMaxBatchSize
+		case "job-workers":
+			cfg.Jobs.Workers = *jobWorkers
+		case "job-queue-size":
+			cfg.Jobs.QueueSize = *jobQueueSize
+		case "fetch-timeout":
+			cfg.Fetch.Timeout = *fetchTimeout
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = *shutdownTimeout
+		}
+	})
+
+	return cfg, cfg.validate()
+}
+
+// Overrides the configuration with the environment variables that are set
+func applyEnv(cfg *Config) error {
+	// PORT is what Heroku sets, LISTEN_ADDR wins if both are there
+	if port := os.Getenv("PORT"); port != "" {
+		cfg.Listen = ":" + port
+	}
+
+	vars := map[string]*string{
+		"LISTEN_ADDR":                   &cfg.Listen,
+		"STORE":                         &cfg.Storage.Backend,
+		"MONGODB_URI":                   &cfg.Storage.URI,
+		"MONGODB_DATABASE":              &cfg.Storage.Database,
+		"MONGODB_TRACKS_COLLECTION":     &cfg.Storage.TracksCollection,
+		"MONGODB_WEBHOOKS_COLLECTION":   &cfg.Storage.WebhooksCollection,
+		"MONGODB_COUNTERS_COLLECTION":   &cfg.Storage.CountersCollection,
+		"MONGODB_TRACK_DATA_COLLECTION": &cfg.Storage.TrackDataCollection,
+		"BOLT_PATH":                     &cfg.Storage.Path,
+		"ADMIN_USER":                    &cfg.Admin.Username,
+		"ADMIN_PASSWORD":                &cfg.Admin.Password,
+	}
+	for name, value := range vars {
+		if env := os.Getenv(name); env != "" {
+			*value = env
+		}
+	}
+
+	ints := map[string]*int{
+		"TICKER_PAGE_SIZE":      &cfg.Ticker.PageSize,
+		"MONGODB_MAX_POOL_SIZE": &cfg.Storage.MaxPoolSize,
+		"JOB_WORKERS":           &cfg.Jobs.Workers,
+		"JOB_QUEUE_SIZE":        &cfg.Jobs.QueueSize,
+		"FETCH_MAX_REDIRECTS":   &cfg.Fetch.MaxRedirects,
+	}
+	for name, value := range ints {
+		if env := os.Getenv(name); env != "" {
+			n, err := strconv.Atoi(env)
+			if err != nil {
+				return fmt.Errorf("%s must be a number, got %q", name, env)
+			}
+			*value = n
+		}
+	}
+
+	sizes := map[string]*int64{
+		"UPLOAD_MAX_SIZE":       &cfg.Upload.MaxSize,
+		"UPLOAD_MAX_BATCH_SIZE": &cfg.Upload.MaxBatchSize,
+	}
+	for name, value := range sizes {
+		if env := os.Getenv(name); env != "" {
+			n, err := strconv.ParseInt(env, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be a number of bytes, got %q", name, env)
+			}
+			*value = n
+		}
+	}
+
+	if env := os.Getenv("FETCH_SCHEMES"); env != "" {
+		cfg.Fetch.Schemes = strings.Split(env, ",")
+	}
+	if env := os.Getenv("VALIDATION_REJECT"); env != "" {
+		cfg.Validation.Reject = strings.Split(env, ",")
+	}
+	if env := os.Getenv("VALIDATION_HIDE"); env != "" {
+		cfg.Validation.Hide = strings.Split(env, ",")
+	}
+	if env := os.Getenv("FETCH_ALLOW_PRIVATE"); env != "" {
+		allow, err := strconv.ParseBool(env)
+		if err != nil {
+			return fmt.Errorf("FETCH_ALLOW_PRIVATE must be true or false, got %q", env)
+		}
+		cfg.Fetch.AllowPrivate = allow
+	}
+
+	floats := map[string]*float64{
+		"SCORE_FREE_DISTANCE":  &cfg.Scoring.FreeDistance,
+		"SCORE_FLAT_TRIANGLE":  &cfg.Scoring.FlatTriangle,
+		"SCORE_FAI_TRIANGLE":   &cfg.Scoring.FAITriangle,
+		"SCORE_CLOSING_RATIO":  &cfg.Scoring.ClosingRatio,
+		"TASK_CYLINDER_RADIUS": &cfg.Task.CylinderRadius,
+	}
+	for name, value := range floats {
+		if env := os.Getenv(name); env != "" {
+			f, err := strconv.ParseFloat(env, 64)
+			if err != nil {
+				return fmt.Errorf("%s must be a number, got %q", name, env)
+			}
+			*value = f
+		}
+	}
+
+	durations := map[string]*time.Duration{
+		"WEBHOOK_TIMEOUT":  &cfg.Webhook.Timeout,
+		"QUERY_TIMEOUT":    &cfg.Storage.QueryTimeout,
+		"SHUTDOWN_TIMEOUT": &cfg.ShutdownTimeout,
+		"JOB_TIMEOUT":      &cfg.Jobs.Timeout,
+		"JOB_RETENTION":    &cfg.Jobs.Retention,
+		"FETCH_TIMEOUT":    &cfg.Fetch.Timeout,
+	}
+	for name, value := range durations {
+		if env := os.Getenv(name); env != "" {
+			d, err := time.ParseDuration(env)
+			if err != nil {
+				return fmt.Errorf("%s must be a duration like 10s, got %q", name, env)
+			}
+			*value = d
+		}
+	}
+
+	return nil
+}
+
+// Checks that the configuration can be used, and lists every problem found
+func (cfg Config) validate() error {
+	var problems []string
+
+	if cfg.Listen == "" {
+		problems = append(problems, "the listen address is empty")
+	}
+
+	switch cfg.Storage.Backend {
+	case "memory":
+	case "bolt":
+		if cfg.Storage.Path == "" {
+			problems = append(problems, "the bolt backend needs a file path (BOLT_PATH or -bolt-path)")
+		}
+	case "mongo":
+		if cfg.Storage.URI == "" {
+			problems = append(problems, "the mongo backend needs a connection string (MONGODB_URI or -mongo-uri), or use STORE=memory to run without a database")
+		}
+		if cfg.Storage.Database == "" || cfg.Storage.TracksCollection == "" || cfg.Storage.WebhooksCollection == "" ||
+			cfg.Storage.CountersCollection == "" || cfg.Storage.TrackDataCollection == "" {
+			problems = append(problems, "the mongo database and collection names can't be empty")
+		}
+		if cfg.Storage.MaxPoolSize < 1 || cfg.Storage.MaxPoolSize > math.MaxUint16 {
+			problems = append(problems, fmt.Sprintf("the mongo pool size must be between 1 and %d, got %d", math.MaxUint16, cfg.Storage.MaxPoolSize))
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown storage backend %q, use memory, bolt or mongo", cfg.Storage.Backend))
+	}
+
+	if cfg.Ticker.PageSize < 1 {
+		problems = append(problems, fmt.Sprintf("the ticker page size must be at least 1, got %d", cfg.Ticker.PageSize))
+	}
+
+	if cfg.Storage.QueryTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("the query timeout must be positive, got %s", cfg.Storage.QueryTimeout))
+	}
+
+	if cfg.ShutdownTimeout <= 0 {
+		problems = append(problems, fmt.Sprintf("the shutdown timeout must be positive, got %s", cfg.ShutdownTimeout))
+	}
+
+	if cfg.Webhook.Timeout <= 0 {
+		problems = append(problems, fmt.Sprintf("the webhook timeout must be positive, got %s", cfg.Webhook.Timeout))
+	}
+
+	if cfg.Upload.MaxSize < 1 {
+		problems = append(problems, fmt.Sprintf("the upload max size must be positive, got %d", cfg.Upload.MaxSize))
+	}
+	if cfg.Upload.MaxBatchSize < 1 {
+		problems = append(problems, fmt.Sprintf("the upload max batch size must be positive, got %d", cfg.Upload.MaxBatchSize))
+	}
+
+	if cfg.Jobs.Workers < 1 {
+		problems = append(problems, fmt.Sprintf("there must be at least one job worker, got %d", cfg.Jobs.Workers))
+	}
+	if cfg.Jobs.QueueSize < 1 {
+		problems = append(problems, fmt.Sprintf("the job queue size must be positive, got %d", cfg.Jobs.QueueSize))
+	}
+	if cfg.Jobs.Timeout <= 0 || cfg.Jobs.Retention <= 0 {
+		problems = append(problems, "the job timeout and retention must be positive")
+	}
+
+	if cfg.Fetch.Timeout <= 0 {
+		problems = append(problems, fmt.Sprintf("the fetch timeout must be positive, got %s", cfg.Fetch.Timeout))
+	}
+	if cfg.Fetch.MaxRedirects < 0 {
+		problems = append(problems, fmt.Sprintf("the fetch max redirects can't be negative, got %d", cfg.Fetch.MaxRedirects))
+	}
+	if len(cfg.Fetch.Schemes) == 0 {
+		problems = append(problems, "at least one URL scheme must be allowed for fetching")
+	}
+	for _, scheme := range cfg.Fetch.Schemes {
+		if scheme != "http" && scheme != "https" {
+			problems = append(problems, fmt.Sprintf("only the http and https schemes can be fetched, got %q", scheme))
+		}
+	}
+
+	if cfg.Scoring.FreeDistance <= 0 || cfg.Scoring.FlatTriangle <= 0 || cfg.Scoring.FAITriangle <= 0 {
+		problems = append(problems, "the scoring multipliers must be positive")
+	}
+	if cfg.Scoring.ClosingRatio < 0 || cfg.Scoring.ClosingRatio >= 1 {
+		problems = append(problems, fmt.Sprintf("the scoring closing ratio must be between 0 and 1, got %g", cfg.Scoring.ClosingRatio))
+	}
+
+	if cfg.Task.CylinderRadius <= 0 {
+		problems = append(problems, fmt.Sprintf("the task cylinder radius must be positive, got %g", cfg.Task.CylinderRadius))
+	}
+
+	for _, status := range append(append([]string{}, cfg.Validation.Reject...), cfg.Validation.Hide...) {
+		if !hasValidationStatus(validationStatuses, status) {
+			problems = append(problems, fmt.Sprintf("unknown validation status %q, use %s", status, strings.Join(validationStatuses, ", ")))
+		}
+	}
+
+	if (cfg.Admin.Username == "") != (cfg.Admin.Password == "") {
+		problems = append(problems, "the admin username and password must be set together")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
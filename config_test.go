@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_loadConfig_Precedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "paragliding.yaml")
+	content := `
+listen: ":9000"
+storage:
+  backend: bolt
+  path: /tmp/from-file.db
+ticker:
+  page_size: 10
+webhook:
+  timeout: 3s
+`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TICKER_PAGE_SIZE", "7")
+	t.Setenv("BOLT_PATH", "/tmp/from-env.db")
+
+	cfg, err := loadConfig([]string{"-config", file, "-bolt-path", "/tmp/from-flag.db"})
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+
+	if cfg.Listen != ":9000" || cfg.Storage.Backend != "bolt" || cfg.Webhook.Timeout != 3*time.Second {
+		t.Errorf("Expected the values from the file, got %+v", cfg)
+	}
+	if cfg.Ticker.PageSize != 7 {
+		t.Errorf("Expected the environment to override the file, got page size %d", cfg.Ticker.PageSize)
+	}
+	if cfg.Storage.Path != "/tmp/from-flag.db" {
+		t.Errorf("Expected the flag to override the environment, got %s", cfg.Storage.Path)
+	}
+}
+
+func Test_loadConfig_Invalid(t *testing.T) {
+	t.Setenv("STORE", "mongo")
+	t.Setenv("MONGODB_URI", "")
+
+	_, err := loadConfig([]string{"-ticker-page-size", "0", "-admin-user", "admin"})
+	if err == nil {
+		t.Fatal("Expected an error for an invalid configuration")
+	}
+
+	for _, problem := range []string{"MONGODB_URI", "page size", "admin username and password"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected the error to mention %q, got %s", problem, err)
+		}
+	}
+}
+
+func Test_loadConfig_Memory(t *testing.T) {
+	t.Setenv("STORE", "memory")
+	t.Setenv("PORT", "5000")
+
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if cfg.Listen != ":5000" {
+		t.Errorf("Expected PORT to set the listen address, got %s", cfg.Listen)
+	}
+}
+
+func Test_loadConfig_Scoring(t *testing.T) {
+	t.Setenv("STORE", "memory")
+	t.Setenv("SCORE_FAI_TRIANGLE", "2")
+
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if cfg.Scoring.FAITriangle != 2 || cfg.Scoring.FreeDistance != 1 {
+		t.Errorf("Expected the FAI multiplier from the environment, got %+v", cfg.Scoring)
+	}
+
+	t.Setenv("SCORE_CLOSING_RATIO", "1.5")
+	if _, err := loadConfig(nil); err == nil || !strings.Contains(err.Error(), "closing ratio") {
+		t.Errorf("Expected an error for the closing ratio, got %v", err)
+	}
+	t.Setenv("SCORE_CLOSING_RATIO", "a lot")
+	if _, err := loadConfig(nil); err == nil || !strings.Contains(err.Error(), "SCORE_CLOSING_RATIO") {
+		t.Errorf("Expected an error for the closing ratio, got %v", err)
+	}
+}
+
+func Test_loadConfig_Validation(t *testing.T) {
+	t.Setenv("STORE", "memory")
+	t.Setenv("VALIDATION_REJECT", "unsigned,invalid")
+
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("Unexpected error, %s", err)
+	}
+	if len(cfg.Validation.Reject) != 2 || cfg.Validation.Reject[1] != "invalid" || len(cfg.Validation.Hide) != 0 {
+		t.Errorf("Expected the rejected statuses from the environment, got %+v", cfg.Validation)
+	}
+
+	t.Setenv("VALIDATION_HIDE", "tampered")
+	if _, err := loadConfig(nil); err == nil || !strings.Contains(err.Error(), `"tampered"`) {
+		t.Errorf("Expected an error for the unknown status, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// *** CSV EXPORT *** //
+
+// Protects a text value from being read as a formula by the spreadsheets, the pilot and glider come from the uploaded files
+func csvText(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// Writes the track table as CSV, with the names of the columns on the first line
+func writeTableCSV(w io.Writer, table trackTable) error {
+	writer := csv.NewWriter(w)
+	writer.Write(table.Columns)
+	for _, row := range table.Rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			if table.Numeric[i] {
+				record[i] = value
+			} else {
+				record[i] = csvText(value)
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Writes the fixes of the track as CSV, one line per fix with its time, position and both altitudes in meters
+func writeFixesCSV(w io.Writer, track tracks, data trackData, options url.Values) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"time", "lat", "lon", "gps_altitude", "pressure_altitude"})
+	for _, f := range data.Fixes {
+		writer.Write([]string{
+			formatGPXTime(f.Time),
+			formatDegrees(f.Lat),
+			formatDegrees(f.Lon),
+			strconv.FormatInt(f.GPSAltitude, 10),
+			strconv.FormatInt(f.PressureAltitude, 10),
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
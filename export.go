@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// *** TRACK EXPORT *** //
+
+// trackExport is a file format the tracks can be exported to
+type trackExport struct {
+	ContentType string
+	Extension   string
+	// Writes the track with its fixes, the options are the query of the request
+	Write func(w io.Writer, track tracks, data trackData, options url.Values) error
+}
+
+// exportOptionError is returned by the writers when an option of the query is wrong
+type exportOptionError string
+
+func (e exportOptionError) Error() string {
+	return string(e)
+}
+
+// The formats of GET /api/track/<id>/export, by the name used in ?format=
+var trackExports = map[string]trackExport{
+	"gpx":     {ContentType: "application/gpx+xml", Extension: "gpx", Write: writeGPX},
+	"kml":     {ContentType: "application/vnd.google-earth.kml+xml", Extension: "kml", Write: writeKML},
+	"kmz":     {ContentType: "application/vnd.google-earth.kmz", Extension: "kmz", Write: writeKMZ},
+	"geojson": {ContentType: "application/geo+json", Extension: "geojson", Write: writeGeoJSON},
+	"csv":     {ContentType: "text/csv; charset=utf-8", Extension: "csv", Write: writeFixesCSV},
+}
+
+// Returns the names of the export formats, sorted, for the error messages
+func exportFormats() []string {
+	formats := make([]string, 0, len(trackExports))
+	for format := range trackExports {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// Handles path: GET /api/track/<id>/export?format=<format>
+// Returns the track as a file to download, in one of the trackExports formats
+func handlerExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	export, ok := trackExports[strings.ToLower(r.URL.Query().Get("format"))]
+	if !ok {
+		http.Error(w, "400 - Bad Request, the format must be one of "+strings.Join(exportFormats(), ", "), http.StatusBadRequest)
+		return
+	}
+
+	data, ok := requestedTrackData(w, r)
+	if !ok {
+		return
+	}
+	track, found, err := tracksDB.TrackByID(r.Context(), data.UniqueID)
+	if err != nil {
+		http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "404 - The trackInfo with that id doesn't exists in our database ", http.StatusNotFound)
+		return
+	}
+
+	// Written to a buffer first, so a failure is still answered with an error status
+	var file bytes.Buffer
+	err = export.Write(&file, track, data, r.URL.Query())
+	if problem, ok := err.(exportOptionError); ok {
+		http.Error(w, "400 - Bad Request, "+problem.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "500 - Could not export the track", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="track-`+track.UniqueID+`.`+export.Extension+`"`)
+	w.Write(file.Bytes())
+}
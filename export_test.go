@@ -0,0 +1,372 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// go test -run Export -update writes the golden files again, check their diff before committing them
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// Returns the response of GET /api/track/<id>/export with the query
+func getExport(t *testing.T, id, query string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/"+id+"/export"+query, nil))
+	return rec
+}
+
+// Compares the content with the golden file in testdata, or writes it with -update
+func checkGolden(t *testing.T, name string, content []byte) {
+	path := "testdata/" + name
+	if *updateGolden {
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatalf("Error writing %s, %s", path, err)
+		}
+	}
+	expected, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading %s, %s", path, err)
+	}
+	if !bytes.Equal(content, expected) {
+		t.Errorf("The export is not the same as %s, run the test with -update to see the difference", path)
+	}
+}
+
+func Test_handlerExport_GPX(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	rec := getExport(t, "1", "?format=gpx")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/gpx+xml" {
+		t.Fatalf("Expected the GPX file, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); disposition != `attachment; filename="track-1.gpx"` {
+		t.Errorf("Unexpected Content-Disposition %q", disposition)
+	}
+	checkGolden(t, "optimize-short-flight-1.gpx", rec.Body.Bytes())
+
+	doc := gpxDocument{}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected a valid XML document, got %s", err)
+	}
+	if len(doc.Track.Segments) != 1 || len(doc.Track.Segments[0].Points) != 640 || doc.Metadata.Author.Name != "Dijon Planeurs CDVV" {
+		t.Errorf("Expected the 640 fixes of the flight and the pilot, got %+v", doc.Metadata)
+	}
+}
+
+func Test_handlerExport_GPXSegments(t *testing.T) {
+	useMemoryTracks(t)
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1)
+	fixes = moveNorth(fixes, 20*time.Minute, 0, 0)
+	fixes = moveNorth(fixes, 10*time.Minute, 30, -1)
+
+	ts := httptest.NewServer(http.HandlerFunc(handlerTrack))
+	defer ts.Close()
+	resp, err := http.Post(ts.URL+"?split=false", "application/octet-stream", bytes.NewReader(encodeIGC(fixes, "HFCCLCOMPETITIONCLASS:Club")))
+	if err != nil {
+		t.Fatalf("Error making the POST request, %s", err)
+	}
+	resp.Body.Close()
+
+	doc := gpxDocument{}
+	if err := xml.Unmarshal(getExport(t, "1", "?format=gpx").Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected a valid XML document, got %s", err)
+	}
+	if len(doc.Track.Segments) != 2 || doc.Metadata.Name != "Track 1 2018-07-14" {
+		t.Errorf("Expected a segment for each flight of the file, got %d named %q", len(doc.Track.Segments), doc.Metadata.Name)
+	}
+	if doc.Track.Type != "Club" {
+		t.Errorf("Expected the competition class as the type of the track, got %q", doc.Track.Type)
+	}
+}
+
+func Test_handlerExport_Errors(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	if rec := getExport(t, "1", "?format=doc"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest %d for an unknown format, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec := getExport(t, "2", "?format=gpx"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected StatusNotFound %d for a missing track, got %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func Test_handlerExport_KML(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	rec := getExport(t, "1", "?format=kml&extrude=true")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/vnd.google-earth.kml+xml" {
+		t.Fatalf("Expected the KML file, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	doc := kmlDocument{}
+	if err := xml.Unmarshal(rec.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected a valid XML document, got %s", err)
+	}
+
+	if span := doc.Document.TimeSpan; span == nil || span.Begin != "2017-08-09T12:12:43Z" {
+		t.Errorf("Expected the time span of the track, got %+v", span)
+	}
+	placemarks := doc.Document.Placemarks
+	if len(placemarks) != 2 || placemarks[0].Name != "Takeoff" || placemarks[1].TimeStamp.When != "2017-08-09T12:52:35Z" {
+		t.Errorf("Expected the takeoff and the landing placemarks, got %+v", placemarks)
+	}
+	if len(doc.Document.Styles) != len(climbColours) {
+		t.Errorf("Expected a style for every climb colour, got %d", len(doc.Document.Styles))
+	}
+
+	// The lines follow each other, from the first fix to the last, with a different colour every time
+	lines := doc.Document.Folder.Placemarks
+	if len(lines) < 10 {
+		t.Fatalf("Expected the track to change colour in the thermals, got %d lines", len(lines))
+	}
+	for i, line := range lines {
+		if line.LineString.Extrude != 1 || line.LineString.AltitudeMode != "absolute" {
+			t.Errorf("Expected extruded lines at their absolute altitude, got %+v", line.LineString)
+		}
+		if i > 0 && (line.TimeSpan.Begin != lines[i-1].TimeSpan.End || line.StyleURL == lines[i-1].StyleURL) {
+			t.Errorf("Line %d doesn't continue the previous one, %+v %+v", i, lines[i-1].TimeSpan, line.TimeSpan)
+		}
+	}
+
+	// Without the option the lines are not extruded
+	doc = kmlDocument{}
+	xml.Unmarshal(getExport(t, "1", "?format=kml").Body.Bytes(), &doc)
+	if doc.Document.Folder.Placemarks[0].LineString.Extrude != 0 {
+		t.Errorf("Expected the lines not to be extruded by default")
+	}
+}
+
+func Test_newKMLDocument_Short(t *testing.T) {
+	// Shorter than the climb window, the rate is unknown and must not look like a strong sink or climb
+	fixes := moveNorth(nil, 5*time.Second, 30, -3)
+	doc := newKMLDocument(tracks{UniqueID: "1"}, fixes, false)
+
+	lines := doc.Document.Folder.Placemarks
+	if len(lines) != 1 || lines[0].StyleURL != "#climb"+strconv.Itoa(climbColour(0)) {
+		t.Errorf("Expected a single line coloured as level flight, got %+v", lines)
+	}
+	if climbColour(math.NaN()) != climbColour(0) {
+		t.Errorf("Expected an unknown rate to be coloured as level flight, got %d", climbColour(math.NaN()))
+	}
+}
+
+func Test_handlerExport_KMZ(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	rec := getExport(t, "1", "?format=kmz")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Disposition") != `attachment; filename="track-1.kmz"` {
+		t.Fatalf("Expected the KMZ file, got %d %s", rec.Code, rec.Header().Get("Content-Disposition"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil || len(archive.File) != 1 || archive.File[0].Name != "doc.kml" {
+		t.Fatalf("Expected a ZIP archive with doc.kml, got %v", err)
+	}
+	file, _ := archive.File[0].Open()
+	content, _ := ioutil.ReadAll(file)
+	if !bytes.Equal(content, getExport(t, "1", "?format=kml").Body.Bytes()) {
+		t.Errorf("Expected doc.kml to be the KML export")
+	}
+}
+
+func Test_handlerExport_GeoJSON(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	rec := getExport(t, "1", "?format=geojson")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/geo+json" {
+		t.Fatalf("Expected the GeoJSON file, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	feature := geoJSONFeature{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &feature); err != nil {
+		t.Fatalf("Expected valid JSON, got %s", err)
+	}
+	if feature.Type != "Feature" || feature.Geometry.Type != "LineString" || len(feature.Geometry.Coordinates) != 640 {
+		t.Errorf("Expected a line of the 640 fixes, got %s", rec.Body.String()[:200])
+	}
+	if first := feature.Geometry.Coordinates[0]; first[0] != 4.9482 || first[1] != 47.3873 || first[2] != 1266 {
+		t.Errorf("Expected longitude, latitude and altitude, got %v", first)
+	}
+	if p := feature.Properties; p.ID != "1" || p.Pilot != "Dijon Planeurs CDVV" || p.Glider != "DG 500" || p.Date != "2017-08-09" || p.Length <= 0 {
+		t.Errorf("Unexpected properties, %+v", p)
+	}
+
+	if rec := getExport(t, "1", "?format=geojson&tolerance=far"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest %d for a wrong tolerance, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func Test_handlerTracksGeoJSON(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+	postTrack(t, "application/octet-stream", encodeIGC(moveNorth(nil, 10*time.Minute, 30, 0), "HFPLTPILOT:Jane Doe"))
+	// A track registered before the files were kept
+	tracksDB.InsertTrack(context.Background(), tracks{UniqueID: "3", Pilot: "Jane Doe"})
+
+	get := func(query string) (int, geoJSONCollection) {
+		rec := httptest.NewRecorder()
+		newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/geojson"+query, nil))
+		collection := geoJSONCollection{}
+		json.NewDecoder(rec.Body).Decode(&collection)
+		return rec.Code, collection
+	}
+
+	status, all := get("")
+	if status != http.StatusOK || all.Type != "FeatureCollection" || len(all.Features) != 3 {
+		t.Fatalf("Expected the 3 tracks, got %d %+v", status, all)
+	}
+	// Simplified by default, the straight line of the second track keeps its ends
+	if len(all.Features[0].Geometry.Coordinates) >= 640 || len(all.Features[1].Geometry.Coordinates) != 2 || all.Features[2].Geometry != nil {
+		t.Errorf("Expected simplified lines, and no geometry without the file")
+	}
+
+	_, exact := get("?tolerance=0")
+	if len(exact.Features[0].Geometry.Coordinates) != 640 {
+		t.Errorf("Expected every fix without simplification, got %d", len(exact.Features[0].Geometry.Coordinates))
+	}
+
+	_, filtered := get("?pilot=jane%20doe&min_length=1")
+	if len(filtered.Features) != 1 || filtered.Features[0].Properties.ID != "2" {
+		t.Errorf("Expected only the track of Jane Doe with a flight, got %+v", filtered.Features)
+	}
+
+	if status, _ := get("?status=tampered"); status != http.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest %d for a wrong filter, got %d", http.StatusBadRequest, status)
+	}
+}
+
+func Test_handlerExport_CSV(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	rec := getExport(t, "1", "?format=csv")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Disposition") != `attachment; filename="track-1.csv"` {
+		t.Fatalf("Expected the CSV file, got %d %s", rec.Code, rec.Header().Get("Content-Disposition"))
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Expected valid CSV, got %s", err)
+	}
+	if len(records) != 641 || strings.Join(records[0], ",") != "time,lat,lon,gps_altitude,pressure_altitude" {
+		t.Fatalf("Expected the names of the columns and the 640 fixes, got %d lines", len(records))
+	}
+	if first := strings.Join(records[1], ","); first != "2017-08-09T12:12:43Z,47.387300,4.948200,0,1266" {
+		t.Errorf("Unexpected first fix, got %s", first)
+	}
+}
+
+// Gets the track table in the format, with the filters of the query
+func getTracksExport(t *testing.T, query string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/export"+query, nil))
+	return rec
+}
+
+func Test_handlerTracksExport(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+	postTrack(t, "application/octet-stream", encodeIGC(moveNorth(nil, 10*time.Minute, 30, 0), "HFPLTPILOT:=Jane Doe"))
+	// A track registered before the analysis
+	tracksDB.InsertTrack(context.Background(), tracks{UniqueID: "3", Pilot: "Jane Doe", TrackLength: 12})
+
+	rec := getTracksExport(t, "?format=csv")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Disposition") != `attachment; filename="tracks.csv"` {
+		t.Fatalf("Expected the CSV file, got %d %s", rec.Code, rec.Header().Get("Content-Disposition"))
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(records) != 4 {
+		t.Fatalf("Expected the names of the columns and the 3 tracks, got %d lines, %v", len(records), err)
+	}
+	columns := strings.Join(records[0], ",")
+	if !strings.HasPrefix(columns, "id,pilot,glider,glider_id,H_date,length,time_recorded,takeoff_time,landing_time,duration,airborne_time,score,max_altitude,") {
+		t.Errorf("Unexpected columns, got %s", columns)
+	}
+	if sample := records[1]; sample[0] != "1" || sample[1] != "Dijon Planeurs CDVV" || sample[7] != "2017-08-09T12:12:43Z" || sample[9] != "2392" {
+		t.Errorf("Unexpected row of the sample, got %v", sample)
+	}
+	if records[2][1] != "'=Jane Doe" {
+		t.Errorf("Expected the pilot to be kept from the formulas, got %s", records[2][1])
+	}
+	if stored := records[3]; stored[5] != "12.0000" || strings.Join(stored[7:], "") != "" {
+		t.Errorf("Expected no derived values before the analysis, got %v", stored)
+	}
+
+	if rec := getTracksExport(t, "?format=csv&min_length=6"); strings.Count(rec.Body.String(), "\n") != 3 {
+		t.Errorf("Expected the filters of the track list, got %s", rec.Body.String())
+	}
+	if rec := getTracksExport(t, "?format=pdf"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest %d for an unknown format, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if rec := getTracksExport(t, "?format=csv&after=yesterday"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest %d for a wrong filter, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func Test_handlerTracksExport_XLSX(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	rec := getTracksExport(t, "?format=xlsx")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" {
+		t.Fatalf("Expected the workbook, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("Expected a zip archive, got %s", err)
+	}
+	parts := map[string]string{}
+	for _, file := range archive.File {
+		content, _ := file.Open()
+		raw, _ := ioutil.ReadAll(content)
+		parts[file.Name] = string(raw)
+		// Every part must be well formed
+		decoder := xml.NewDecoder(bytes.NewReader(raw))
+		for {
+			if _, err := decoder.Token(); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Expected well formed XML in %s, got %s", file.Name, err)
+			}
+		}
+	}
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("Expected the part %s in the workbook", name)
+		}
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, cell := range []string{
+		`<c r="A1" t="inlineStr"><is><t xml:space="preserve">id</t></is></c>`,
+		`<c r="B2" t="inlineStr"><is><t xml:space="preserve">Dijon Planeurs CDVV</t></is></c>`,
+		`<c r="J2"><v>2392</v></c>`,
+	} {
+		if !strings.Contains(sheet, cell) {
+			t.Errorf("Expected the cell %s in the sheet", cell)
+		}
+	}
+}
+
+func Test_xlsxColumn(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 701: "ZZ", 702: "AAA"} {
+		if got := xlsxColumn(i); got != want {
+			t.Errorf("Expected column %d to be %s, got %s", i, want, got)
+		}
+	}
+}
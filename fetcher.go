@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+)
+
+// *** REMOTE FETCHING *** //
+
+// Reasons for refusing to fetch a URL, returned to the client in the "error" field
+const (
+	fetchInvalidURL       = "invalid_url"
+	fetchSchemeNotAllowed = "scheme_not_allowed"
+	fetchAddressBlocked   = "address_blocked"
+	fetchTooManyRedirects = "too_many_redirects"
+	fetchTooLarge         = "too_large"
+	fetchTimeout          = "timeout"
+	fetchUnreachable      = "unreachable"
+	fetchBadStatus        = "bad_status"
+)
+
+// fetchError explains why the IGC file at a URL could not be fetched
+type fetchError struct {
+	Reason  string `json:"error"`
+	Message string `json:"message"`
+	URL     string `json:"url"`
+}
+
+func (e *fetchError) Error() string {
+	return "could not fetch the IGC file: " + e.Message
+}
+
+// Status is the HTTP status answered to the client for this error
+func (e *fetchError) Status() int {
+	switch e.Reason {
+	case fetchAddressBlocked:
+		return http.StatusForbidden
+	case fetchTooLarge:
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// Writes the error as JSON, with its status
+func (e *fetchError) respond(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status())
+	json.NewEncoder(w).Encode(e)
+}
+
+// errBlockedAddress is returned by the dialer, it is turned into a fetchError with the address
+type errBlockedAddress struct {
+	ip net.IP
+}
+
+func (e errBlockedAddress) Error() string {
+	return fmt.Sprintf("the address %s is private, loopback or link-local", e.ip)
+}
+
+// fetcher downloads the IGC files of the URLs posted to the API
+// The URLs come from the clients, so it refuses the addresses of the internal network
+// (the checks are done on the address actually dialled, after the DNS lookup and on every redirect)
+// and limits the time, size and redirects of the download
+type fetcher struct {
+	client  *http.Client
+	schemes map[string]bool
+	maxSize int64
+}
+
+// The fetcher used by the handlers, main() replaces it with one using the loaded configuration
+var igcFetcher = newFetcher(config.Fetch, config.Upload.MaxSize)
+
+func newFetcher(cfg FetchConfig, maxSize int64) *fetcher {
+	f := &fetcher{schemes: map[string]bool{}, maxSize: maxSize}
+	for _, scheme := range cfg.Schemes {
+		f.schemes[strings.ToLower(scheme)] = true
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = blockPrivateAddresses
+	}
+
+	f.client = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.Timeout,
+			ResponseHeaderTimeout: cfg.Timeout,
+			// A proxy from the environment would dial the address itself, and skip the checks
+			Proxy: nil,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > cfg.MaxRedirects {
+				return &fetchError{Reason: fetchTooManyRedirects, Message: fmt.Sprintf("more than %d redirects", cfg.MaxRedirects)}
+			}
+			return f.checkScheme(req.URL)
+		},
+	}
+	return f
+}
+
+// Refuses the connections to private, loopback, link-local and unspecified addresses
+// It runs after the DNS lookup, so a public name resolving to an internal address is refused too
+func blockPrivateAddresses(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isBlockedIP(ip) {
+		return errBlockedAddress{ip}
+	}
+	return nil
+}
+
+// The ranges isBlockedIP refuses on top of the ones the net package knows about
+var blockedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // "This network", Linux routes it to the local machine
+	mustParseCIDR("100.64.0.0/10"), // Carrier-grade NAT, shared between the customers of a provider
+	mustParseCIDR("64:ff9b::/96"),  // NAT64, the IPv4 address in its last 32 bits can be a private one
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// Returns true for the addresses that are not on the public internet
+func isBlockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range blockedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fetcher) checkScheme(u *url.URL) error {
+	if !f.schemes[strings.ToLower(u.Scheme)] {
+		return &fetchError{Reason: fetchSchemeNotAllowed, Message: fmt.Sprintf("the scheme %q is not allowed", u.Scheme)}
+	}
+	return nil
+}
+
+// Downloads the file at rawURL, or returns a *fetchError explaining why it could not
+func (f *fetcher) fetch(ctx context.Context, rawURL string) ([]byte, error) {
+	content, err := f.get(ctx, rawURL)
+	if err != nil {
+		fetchErr := toFetchError(err)
+		fetchErr.URL = rawURL
+		return nil, fetchErr
+	}
+	return content, nil
+}
+
+func (f *fetcher) get(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return nil, &fetchError{Reason: fetchInvalidURL, Message: "the URL must be absolute, eg: https://example.com/flight.igc"}
+	}
+	if err := f.checkScheme(u); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, &fetchError{Reason: fetchInvalidURL, Message: err.Error()}
+	}
+
+	resp, err := f.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &fetchError{Reason: fetchBadStatus, Message: "the server answered " + resp.Status}
+	}
+	if resp.ContentLength > f.maxSize {
+		return nil, &fetchError{Reason: fetchTooLarge, Message: fmt.Sprintf("the file is bigger than %d bytes", f.maxSize)}
+	}
+
+	// The Content-Length can be missing or wrong, the body is limited as well
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, f.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > f.maxSize {
+		return nil, &fetchError{Reason: fetchTooLarge, Message: fmt.Sprintf("the file is bigger than %d bytes", f.maxSize)}
+	}
+	return content, nil
+}
+
+// Turns the errors of the HTTP client into a *fetchError
+func toFetchError(err error) *fetchError {
+	var fetchErr *fetchError
+	if errors.As(err, &fetchErr) {
+		return fetchErr
+	}
+
+	var blocked errBlockedAddress
+	if errors.As(err, &blocked) {
+		return &fetchError{Reason: fetchAddressBlocked, Message: blocked.Error()}
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &fetchError{Reason: fetchTimeout, Message: "the server took too long to answer"}
+	}
+
+	return &fetchError{Reason: fetchUnreachable, Message: err.Error()}
+}
+
+// Downloads the IGC file from the given URL, with the checks of igcFetcher
+func fetchIGC(ctx context.Context, rawURL string) ([]byte, error) {
+	return igcFetcher.fetch(ctx, rawURL)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Replaces the fetcher for the duration of the test
+// httptest servers listen on the loopback address, the tests that fetch from them allow the private addresses
+func useFetcher(t *testing.T, cfg FetchConfig, maxSize int64) {
+	previous := igcFetcher
+	igcFetcher = newFetcher(cfg, maxSize)
+	t.Cleanup(func() { igcFetcher = previous })
+}
+
+// The default limits, but allowing the httptest servers
+func testFetchConfig() FetchConfig {
+	cfg := defaultConfig().Fetch
+	cfg.AllowPrivate = true
+	return cfg
+}
+
+// Fetches the URL and returns the reason of the error, or "" if it worked
+func fetchReason(t *testing.T, rawURL string) string {
+	_, err := fetchIGC(context.Background(), rawURL)
+	if err == nil {
+		return ""
+	}
+	fetchErr, ok := err.(*fetchError)
+	if !ok {
+		t.Fatalf("Expected a *fetchError, got %T %v", err, err)
+	}
+	if fetchErr.URL != rawURL {
+		t.Errorf("Expected the URL in the error, got %q", fetchErr.URL)
+	}
+	return fetchErr.Reason
+}
+
+func Test_fetcher(t *testing.T) {
+	content := readSampleIGC(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flight.igc", func(w http.ResponseWriter, r *http.Request) { w.Write(content) })
+	mux.HandleFunc("/missing.igc", http.NotFound)
+	mux.HandleFunc("/slow.igc", func(w http.ResponseWriter, r *http.Request) { time.Sleep(200 * time.Millisecond) })
+	mux.HandleFunc("/redirect.igc", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/redirect.igc", http.StatusFound)
+	})
+	mux.HandleFunc("/ftp.igc", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ftp://example.com/flight.igc", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cfg := testFetchConfig()
+	cfg.Timeout = 100 * time.Millisecond
+	useFetcher(t, cfg, int64(len(content)))
+
+	testCases := map[string]string{
+		server.URL + "/flight.igc":   "",
+		server.URL + "/missing.igc":  fetchBadStatus,
+		server.URL + "/slow.igc":     fetchTimeout,
+		server.URL + "/redirect.igc": fetchTooManyRedirects,
+		server.URL + "/ftp.igc":      fetchSchemeNotAllowed,
+		"file:///etc/passwd":         fetchInvalidURL,
+		"gopher://example.com/a.igc": fetchSchemeNotAllowed,
+		"flight.igc":                 fetchInvalidURL,
+	}
+	for rawURL, expected := range testCases {
+		if reason := fetchReason(t, rawURL); reason != expected {
+			t.Errorf("%s: expected %q, got %q", rawURL, expected, reason)
+		}
+	}
+
+	// One byte less than the file
+	useFetcher(t, cfg, int64(len(content))-1)
+	if reason := fetchReason(t, server.URL+"/flight.igc"); reason != fetchTooLarge {
+		t.Errorf("Expected %q, got %q", fetchTooLarge, reason)
+	}
+}
+
+func Test_fetcher_BlocksPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("The private address should not be reached")
+	}))
+	defer server.Close()
+
+	useFetcher(t, defaultConfig().Fetch, 1000)
+
+	// The port of the test server on other names of the same machine
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	for _, host := range []string{"127.0.0.1", "localhost", "[::1]", "0.0.0.0"} {
+		if reason := fetchReason(t, "http://"+host+":"+port+"/flight.igc"); reason != fetchAddressBlocked {
+			t.Errorf("%s: expected %q, got %q", host, fetchAddressBlocked, reason)
+		}
+	}
+}
+
+func Test_isBlockedIP(t *testing.T) {
+	testCases := map[string]bool{
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true, // The metadata service of the cloud providers
+		"100.64.0.1":       true,
+		"0.1.2.3":          true,
+		"64:ff9b::a01:203": true, // 10.1.2.3 through NAT64
+		"64:ff9b::808:808": true,
+		"fd00::1":          true,
+		"fe80::1":          true,
+		"8.8.8.8":          false,
+		"100.128.0.1":      false,
+		"2001:4860::8888":  false,
+	}
+	for address, expected := range testCases {
+		if isBlockedIP(net.ParseIP(address)) != expected {
+			t.Errorf("%s: expected blocked to be %v", address, expected)
+		}
+	}
+}
+
+func Test_handlerTrack_PostBlockedURL(t *testing.T) {
+	useMemoryTracks(t)
+	useFetcher(t, defaultConfig().Fetch, 1000)
+
+	status, body := postTrack(t, "application/json", []byte(`{"url": "http://169.254.169.254/latest/flight.igc"}`))
+	if status != http.StatusForbidden || !strings.Contains(body, `"error":"address_blocked"`) {
+		t.Errorf("Expected StatusForbidden %d with the reason, got %d %s", http.StatusForbidden, status, body)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// *** FLIGHT DETECTION *** //
+
+// Thresholds used to tell flying from walking around the takeoff or GPS drift after landing
+const (
+	// Slower than this the pilot is on the ground, unless the altitude changes fast.
+	// Walking is ~5 km/h, a paraglider flies at 20 km/h or more
+	takeoffSpeed = 15.0 // km/h
+	// Faster climbs or sinks are flying, even with little ground speed (thermalling into the wind)
+	takeoffClimbRate = 1.0 // m/s
+	// Flying shorter than this is ignored, eg. a GPS jump or a short run down the slope
+	minFlyingTime = 30 * time.Second
+	// Being slow for a shorter time is still flying, eg. soaring in front of a ridge in strong wind
+	minGroundTime = 60 * time.Second
+	// On the ground or without fixes for longer than this, the pilot landed and the next takeoff is another flight
+	minSplitGroundTime = 5 * time.Minute
+)
+
+// Mean radius of the earth in km
+const earthRadius = 6371.0
+
+// trackAnalysisVersion is increased when the values derived from the file or its fixes change,
+// the backfill computes them again for the tracks analysed by an older version
+const trackAnalysisVersion = 7
+
+// flightSummary is the part of the track that was actually flown
+type flightSummary struct {
+	Takeoff      int // Index of the takeoff fix, -1 if the track has no flight
+	Landing      int // Index of the landing fix
+	TakeoffTime  time.Time
+	LandingTime  time.Time
+	Duration     time.Duration // From takeoff to landing
+	AirborneTime time.Duration // Duration without the time on the ground in between, eg. after landing and taking off again
+	Length       float64       // Km flown, without the ground segments
+}
+
+// Returns the distance in km between two fixes, on a sphere
+func fixDistance(a, b fix) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// Returns the altitude of the fix in meters, the GPS one or the pressure one when the GPS had no altitude
+func (f fix) altitude() int64 {
+	if f.GPSAltitude != 0 {
+		return f.GPSAltitude
+	}
+	return f.PressureAltitude
+}
+
+// Returns true when the pilot was flying between the two fixes
+func isFlyingSegment(a, b fix) bool {
+	dt := b.Time.Sub(a.Time).Seconds()
+	if dt <= 0 {
+		return false
+	}
+	speed := fixDistance(a, b) / dt * 3600
+	climb := math.Abs(float64(b.altitude()-a.altitude())) / dt
+	return speed >= takeoffSpeed || climb >= takeoffClimbRate
+}
+
+// segmentRun is a sequence of segments that are all flying or all on the ground
+// from and to are fix indexes, the run covers the segments between from and to
+type segmentRun struct {
+	from, to int
+	flying   bool
+}
+
+func (run segmentRun) duration(fixes []fix) time.Duration {
+	return fixes[run.to].Time.Sub(fixes[run.from].Time)
+}
+
+// Groups the segments between the fixes in runs of flying and ground segments,
+// then drops the runs too short to mean anything
+func flightRuns(fixes []fix) []segmentRun {
+	var runs []segmentRun
+	for i := 1; i < len(fixes); i++ {
+		flying := isFlyingSegment(fixes[i-1], fixes[i])
+		if len(runs) > 0 && runs[len(runs)-1].flying == flying {
+			runs[len(runs)-1].to = i
+			continue
+		}
+		runs = append(runs, segmentRun{from: i - 1, to: i, flying: flying})
+	}
+
+	// Short flying runs are ground, then short ground runs between flying ones are flying
+	for i := range runs {
+		if runs[i].flying && runs[i].duration(fixes) < minFlyingTime {
+			runs[i].flying = false
+		}
+	}
+	for i := 1; i < len(runs)-1; i++ {
+		if !runs[i].flying && runs[i-1].flying && runs[i+1].flying && runs[i].duration(fixes) < minGroundTime {
+			runs[i].flying = true
+		}
+	}
+
+	// Merging the neighbours that ended up in the same state
+	merged := []segmentRun{}
+	for _, run := range runs {
+		if len(merged) > 0 && merged[len(merged)-1].flying == run.flying {
+			merged[len(merged)-1].to = run.to
+			continue
+		}
+		merged = append(merged, run)
+	}
+	return merged
+}
+
+// Finds the takeoff and the landing in the fixes, and measures the flight between them
+func detectFlight(fixes []fix) flightSummary {
+	summary := flightSummary{Takeoff: -1, Landing: -1}
+
+	for _, run := range flightRuns(fixes) {
+		if !run.flying {
+			continue
+		}
+		if summary.Takeoff < 0 {
+			summary.Takeoff = run.from
+		}
+		summary.Landing = run.to
+		summary.AirborneTime += run.duration(fixes)
+		for i := run.from + 1; i <= run.to; i++ {
+			summary.Length += fixDistance(fixes[i-1], fixes[i])
+		}
+	}
+
+	if summary.Takeoff >= 0 {
+		summary.TakeoffTime = fixes[summary.Takeoff].Time
+		summary.LandingTime = fixes[summary.Landing].Time
+		summary.Duration = summary.LandingTime.Sub(summary.TakeoffTime)
+	}
+	return summary
+}
+
+// Splits the fixes of a file recording several flights, eg. a whole day of launches, into one part per flight
+// The parts are cut after the landings followed by minSplitGroundTime on the ground, and at the gaps of the recording
+// as long; the parts without a flight are dropped. Returns all the fixes as one part when there is a single flight
+func splitFlights(fixes []fix) [][]fix {
+	// The recorder was off for a while, eg. carried back up to the takeoff
+	var chunks [][]fix
+	from := 0
+	for i := 1; i < len(fixes); i++ {
+		if fixes[i].Time.Sub(fixes[i-1].Time) >= minSplitGroundTime {
+			chunks = append(chunks, fixes[from:i])
+			from = i
+		}
+	}
+	chunks = append(chunks, fixes[from:])
+
+	var parts [][]fix
+	for _, chunk := range chunks {
+		runs := flightRuns(chunk)
+		from := 0
+		for i := 1; i < len(runs)-1; i++ {
+			if !runs[i].flying && runs[i-1].flying && runs[i+1].flying && runs[i].duration(chunk) >= minSplitGroundTime {
+				// The landing fix ends the flight, the ground after it belongs to the next one
+				parts = append(parts, chunk[from:runs[i].from+1])
+				from = runs[i].from + 1
+			}
+		}
+		parts = append(parts, chunk[from:])
+	}
+
+	flights := [][]fix{}
+	for _, part := range parts {
+		if detectFlight(part).Takeoff >= 0 {
+			flights = append(flights, part)
+		}
+	}
+	if len(flights) < 2 {
+		return [][]fix{fixes}
+	}
+	return flights
+}
+
+// Sets the values derived from the fixes on the track
+func analyseTrack(track *tracks, fixes []fix) {
+	flight := detectFlight(fixes)
+	track.TrackLength = flight.Length
+	track.TakeoffTime = flight.TakeoffTime
+	track.LandingTime = flight.LandingTime
+	track.Duration = flight.Duration
+	track.AirborneTime = flight.AirborneTime
+	if flight.Takeoff >= 0 {
+		flown := fixes[flight.Takeoff : flight.Landing+1]
+		track.Altitude = computeAltitudeStats(flown)
+		track.Speed = computeSpeedStats(flown, flight.AirborneTime, flight.Length)
+		track.Score = scoreFlight(flown, config.Scoring)
+	} else {
+		track.Altitude = altitudeStats{}
+		track.Speed = speedStats{}
+		track.Score = scoreFlight(nil, config.Scoring)
+	}
+	track.AnalysisVersion = trackAnalysisVersion
+}
+
+// Returns the statistics of the track as they are named and formatted in the API
+func (track tracks) statsFields() []apiField {
+	return append(track.Altitude.apiFields(), track.Speed.apiFields()...)
+}
+
+// Formats a time of the track for the API, empty when there was no flight
+func formatFlightTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Formats a duration of the track for the API, in whole seconds
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
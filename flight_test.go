@@ -0,0 +1,193 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	igc "github.com/marni/goigc"
+)
+
+var flightStart = time.Date(2018, 7, 14, 10, 0, 0, 0, time.UTC)
+
+// Appends to fixes one fix per second for the given time, moving north at the speed (km/h) and climbing at the rate (m/s)
+func moveNorth(fixes []fix, d time.Duration, speed float64, climb float64) []fix {
+	if len(fixes) == 0 {
+		fixes = []fix{{Time: flightStart, Lat: 46, Lon: 7, GPSAltitude: 1500}}
+	}
+	last := fixes[len(fixes)-1]
+	altitude := float64(last.GPSAltitude)
+	for i := 0; i < int(d/time.Second); i++ {
+		altitude += climb
+		last = fix{
+			Time:        last.Time.Add(time.Second),
+			Lat:         last.Lat + speed/3600/earthRadius*180/math.Pi,
+			Lon:         last.Lon,
+			GPSAltitude: int64(math.Round(altitude)),
+		}
+		fixes = append(fixes, last)
+	}
+	return fixes
+}
+
+func Test_fixDistance(t *testing.T) {
+	// One degree of latitude is ~111.2 km
+	d := fixDistance(fix{Lat: 46, Lon: 7}, fix{Lat: 47, Lon: 7})
+	if math.Abs(d-111.19) > 0.01 {
+		t.Errorf("Expected ~111.19 km, got %f", d)
+	}
+}
+
+func Test_detectFlight(t *testing.T) {
+	fixes := moveNorth(nil, 5*time.Minute, 4, 0)      // Walking to the launch
+	fixes = moveNorth(fixes, 10*time.Minute, 30, -1)  // Gliding
+	fixes = moveNorth(fixes, 40*time.Second, 5, 0)    // Soaring almost on the spot, still flying
+	fixes = moveNorth(fixes, 10*time.Minute, 2, 2)    // Climbing in a thermal into the wind
+	fixes = moveNorth(fixes, 5*time.Minute, 35, -1.5) // Gliding to the landing
+	landed := len(fixes) - 1
+	fixes = moveNorth(fixes, 15*time.Minute, 0.5, 0) // GPS drift on the ground
+	fixes = moveNorth(fixes, 10*time.Second, 40, 0)  // A GPS jump
+	fixes = moveNorth(fixes, 5*time.Minute, 0, 0)
+
+	flight := detectFlight(fixes)
+
+	if flight.Takeoff != 5*60 || !flight.TakeoffTime.Equal(flightStart.Add(5*time.Minute)) {
+		t.Errorf("Expected the takeoff after walking 5 minutes, got fix %d at %s", flight.Takeoff, flight.TakeoffTime)
+	}
+	if flight.Landing != landed {
+		t.Errorf("Expected the landing at fix %d, got %d", landed, flight.Landing)
+	}
+	expectedDuration := 25*time.Minute + 40*time.Second
+	if flight.Duration != expectedDuration || flight.AirborneTime != expectedDuration {
+		t.Errorf("Expected a flight of %s, got %s airborne %s", expectedDuration, flight.Duration, flight.AirborneTime)
+	}
+
+	// 5 km gliding, 0.06 km soaring, 0.33 km in the thermal, 2.9 km gliding
+	if math.Abs(flight.Length-8.3) > 0.1 {
+		t.Errorf("Expected ~8.3 km flown, got %f", flight.Length)
+	}
+}
+
+func Test_detectFlight_TwoFlights(t *testing.T) {
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1)
+	fixes = moveNorth(fixes, 20*time.Minute, 0, 0) // Landed, walking back up
+	fixes = moveNorth(fixes, 10*time.Minute, 30, -1)
+
+	flight := detectFlight(fixes)
+	if flight.Duration != 40*time.Minute || flight.AirborneTime != 20*time.Minute {
+		t.Errorf("Expected 40 minutes from the first takeoff to the last landing, 20 in the air, got %s %s", flight.Duration, flight.AirborneTime)
+	}
+}
+
+func Test_detectFlight_NoFlight(t *testing.T) {
+	flight := detectFlight(moveNorth(nil, 10*time.Minute, 3, 0))
+	if flight.Takeoff != -1 || !flight.TakeoffTime.IsZero() || flight.Duration != 0 || flight.Length != 0 {
+		t.Errorf("Expected no flight, got %+v", flight)
+	}
+
+	if flight := detectFlight(nil); flight.Takeoff != -1 {
+		t.Errorf("Expected no flight without fixes, got %+v", flight)
+	}
+}
+
+func Test_analyseTrack_Sample(t *testing.T) {
+	parsed, err := igc.Parse(string(readSampleIGC(t)))
+	if err != nil {
+		t.Fatalf("Error parsing the sample file, %s", err)
+	}
+	fixes := trackFixes(parsed)
+
+	track := tracks{}
+	analyseTrack(&track, fixes)
+
+	// The recording starts in the air, it ends after the landing
+	if !track.TakeoffTime.Equal(fixes[0].Time) || !track.LandingTime.Before(fixes[len(fixes)-1].Time) {
+		t.Errorf("Expected the flight inside the recording %s - %s, got %s - %s",
+			fixes[0].Time, fixes[len(fixes)-1].Time, track.TakeoffTime, track.LandingTime)
+	}
+	if track.Duration <= 0 || track.AirborneTime > track.Duration {
+		t.Errorf("Unexpected duration %s and airborne time %s", track.Duration, track.AirborneTime)
+	}
+	if track.TrackLength <= 0 || track.TrackLength > trackLength(parsed) {
+		t.Errorf("Expected the flown length to be less than the whole recording %f, got %f", trackLength(parsed), track.TrackLength)
+	}
+	if track.AnalysisVersion != trackAnalysisVersion {
+		t.Errorf("Expected the analysis version to be set")
+	}
+}
+
+func Test_handlerField_Flight(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	router := newRouter()
+	testCases := map[string]string{
+		"takeoff_time": "2017-08-09T12:12:43Z",
+		"landing_time": "2017-08-09T12:52:35Z",
+		"duration":     "2392",
+	}
+	for field, expected := range testCases {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/"+field, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != expected {
+			t.Errorf("%s: expected %q, got %d %q", field, expected, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1", nil))
+	if !strings.Contains(rec.Body.String(), "\"takeoff_time\":\"2017-08-09T12:12:43Z\"") {
+		t.Errorf("Expected the takeoff time in the track, got %s", rec.Body.String())
+	}
+}
+
+func Test_splitFlights(t *testing.T) {
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1)
+	firstLanding := len(fixes) - 1
+	fixes = moveNorth(fixes, 20*time.Minute, 0, 0) // Landed, walking back up
+	fixes = moveNorth(fixes, 10*time.Minute, 30, -1)
+
+	flights := splitFlights(fixes)
+	if len(flights) != 2 {
+		t.Fatalf("Expected 2 flights, got %d", len(flights))
+	}
+	if len(flights[0]) != firstLanding+1 || len(flights[0])+len(flights[1]) != len(fixes) {
+		t.Errorf("Expected the first flight to end at the landing fix %d, got %d and %d fixes", firstLanding, len(flights[0]), len(flights[1]))
+	}
+	for i, flight := range flights {
+		if summary := detectFlight(flight); summary.AirborneTime != 10*time.Minute {
+			t.Errorf("Expected flight %d to last 10 minutes, got %s", i+1, summary.AirborneTime)
+		}
+	}
+}
+
+func Test_splitFlights_RecordingGap(t *testing.T) {
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1)
+	second := moveNorth(nil, 10*time.Minute, 30, -1)
+	for i := range second {
+		second[i].Time = second[i].Time.Add(2 * time.Hour) // The recorder was off while driving back to the takeoff
+	}
+	fixes = append(fixes, second...)
+
+	flights := splitFlights(fixes)
+	if len(flights) != 2 || !flights[1][0].Time.Equal(flightStart.Add(2*time.Hour)) {
+		t.Fatalf("Expected 2 flights split at the gap, got %d", len(flights))
+	}
+}
+
+func Test_splitFlights_SingleFlight(t *testing.T) {
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1)
+	fixes = moveNorth(fixes, 3*time.Minute, 0, 0) // Top landing, shorter than minSplitGroundTime
+	fixes = moveNorth(fixes, 10*time.Minute, 30, -1)
+	fixes = moveNorth(fixes, 30*time.Minute, 0, 0) // On the ground after the landing
+
+	if flights := splitFlights(fixes); len(flights) != 1 || len(flights[0]) != len(fixes) {
+		t.Errorf("Expected a single flight with every fix, got %d flights", len(flights))
+	}
+	if flights := splitFlights(moveNorth(nil, 10*time.Minute, 3, 0)); len(flights) != 1 {
+		t.Errorf("Expected the fixes of a track without flight to stay together, got %d parts", len(flights))
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// *** GEOJSON EXPORT *** //
+
+// The fixes of the tracks in a collection are simplified with this tolerance, unless ?tolerance= sets another one
+const defaultCollectionTolerance = 50.0 // m
+
+type geoJSONCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string            `json:"type"`
+	Geometry   *geoJSONLine      `json:"geometry"` // null when the file of the track is not stored
+	Properties geoJSONProperties `json:"properties"`
+}
+
+type geoJSONLine struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"` // Longitude, latitude and altitude in meters
+}
+
+type geoJSONProperties struct {
+	ID     string  `json:"id"`
+	Pilot  string  `json:"pilot"`
+	Glider string  `json:"glider"`
+	Length float64 `json:"length"` // Km flown
+	Date   string  `json:"date"`   // Day of the flight, eg. 2017-08-09
+}
+
+// Builds the GeoJSON feature of the track, the fixes simplified with the tolerance in meters
+// found is false for the tracks without stored fixes, their feature has no geometry
+func newGeoJSONFeature(track tracks, fixes []fix, found bool, tolerance float64) geoJSONFeature {
+	feature := geoJSONFeature{
+		Type: "Feature",
+		Properties: geoJSONProperties{
+			ID:     track.UniqueID,
+			Pilot:  track.Pilot,
+			Glider: track.Glider,
+			Length: math.Round(track.TrackLength*100) / 100,
+		},
+	}
+	if !track.TakeoffTime.IsZero() {
+		feature.Properties.Date = track.TakeoffTime.UTC().Format("2006-01-02")
+	} else if len(fixes) > 0 {
+		feature.Properties.Date = fixes[0].Time.UTC().Format("2006-01-02")
+	}
+	if !found {
+		return feature
+	}
+
+	simplified := simplifyFixes(fixes, tolerance)
+	feature.Geometry = &geoJSONLine{Type: "LineString", Coordinates: make([][]float64, 0, len(simplified))}
+	for _, f := range simplified {
+		feature.Geometry.Coordinates = append(feature.Geometry.Coordinates,
+			[]float64{math.Round(f.Lon*1e6) / 1e6, math.Round(f.Lat*1e6) / 1e6, float64(f.altitude())})
+	}
+	return feature
+}
+
+// Reads ?tolerance=<meters>, or returns the default when it is not set
+func parseTolerance(options url.Values, byDefault float64) (float64, bool) {
+	param := options.Get("tolerance")
+	if param == "" {
+		return byDefault, true
+	}
+	tolerance, err := strconv.ParseFloat(param, 64)
+	return tolerance, err == nil && tolerance >= 0
+}
+
+// Writes the track as a GeoJSON feature, ?tolerance=<meters> simplifies its line
+func writeGeoJSON(w io.Writer, track tracks, data trackData, options url.Values) error {
+	tolerance, ok := parseTolerance(options, 0)
+	if !ok {
+		return exportOptionError("the tolerance must be a number of meters")
+	}
+	return json.NewEncoder(w).Encode(newGeoJSONFeature(track, data.Fixes, true, tolerance))
+}
+
+// Handles path: GET /api/track/geojson
+// Returns a GeoJSON FeatureCollection with a feature for every track listed by GET /api/track with the same filters,
+// the lines simplified with ?tolerance=<meters>, defaultCollectionTolerance otherwise
+func handlerTracksGeoJSON(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	filter, problem := parseTrackFilter(r.URL.Query())
+	if problem != "" {
+		http.Error(w, "400 - Bad Request, "+problem, http.StatusBadRequest)
+		return
+	}
+	tolerance, ok := parseTolerance(r.URL.Query(), defaultCollectionTolerance)
+	if !ok {
+		http.Error(w, "400 - Bad Request, the tolerance must be a number of meters", http.StatusBadRequest)
+		return
+	}
+
+	listed, err := listTracks(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+		return
+	}
+
+	collection := geoJSONCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, 0, len(listed))}
+	for _, track := range listed {
+		data, found, err := tracksDB.TrackData(r.Context(), track.UniqueID)
+		if err != nil {
+			http.Error(w, "500 - Could not read the track", http.StatusInternalServerError)
+			return
+		}
+		collection.Features = append(collection.Features, newGeoJSONFeature(track, data.Fixes, found, tolerance))
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(collection)
+}
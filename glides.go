@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// *** SPEED AND GLIDES *** //
+
+// The top speed is the median over this window, a single segment can be a GPS jump
+const maxSpeedWindow = 10 * time.Second
+
+// speedStats summarises the speeds and glides of a flight
+type speedStats struct {
+	MaxSpeed     float64 `json:"max_speed"`     // Km/h, median over 10 seconds
+	AverageSpeed float64 `json:"average_speed"` // Km/h, the length flown over the airborne time
+	GlideRatio   float64 `json:"glide_ratio"`   // Of all the glides together, 0 when they lost no height
+}
+
+// glide is the flight between two thermals, or between the takeoff or landing and a thermal
+type glide struct {
+	Start        flightPoint `json:"start"`
+	End          flightPoint `json:"end"`
+	Duration     int64       `json:"duration"`      // Seconds
+	Distance     float64     `json:"distance"`      // Km along the track
+	HeightLost   int64       `json:"height_lost"`   // m, negative when the pilot climbed
+	GlideRatio   float64     `json:"glide_ratio"`   // Distance over height lost, 0 when no height was lost
+	AverageSpeed float64     `json:"average_speed"` // Km/h
+	MaxSpeed     float64     `json:"max_speed"`     // Km/h, median over 10 seconds
+}
+
+// speedPoint is the ground speed of the segment ending at a fix
+type speedPoint struct {
+	Time  time.Time `json:"time"`
+	Speed float64   `json:"speed"` // Km/h
+}
+
+// Returns the ground speed of every segment between the fixes, in km/h
+func segmentSpeeds(fixes []fix) []speedPoint {
+	speeds := []speedPoint{}
+	for i := 1; i < len(fixes); i++ {
+		dt := fixes[i].Time.Sub(fixes[i-1].Time).Hours()
+		if dt <= 0 {
+			continue
+		}
+		speed := math.Round(fixDistance(fixes[i-1], fixes[i])/dt*10) / 10
+		speeds = append(speeds, speedPoint{Time: fixes[i].Time, Speed: speed})
+	}
+	return speeds
+}
+
+// Returns the highest speed in km/h kept for the window: the highest median of the segment speeds over
+// the window, so a GPS jump, which goes away and comes back, doesn't count
+func maxSustainedSpeed(fixes []fix, window time.Duration) float64 {
+	speeds := segmentSpeeds(fixes)
+
+	best := 0.0
+	j := 0
+	for i := range speeds {
+		for j < len(speeds) && speeds[j].Time.Sub(speeds[i].Time) < window {
+			j++
+		}
+		values := make([]float64, 0, j-i)
+		for _, speed := range speeds[i:j] {
+			values = append(values, speed.Speed)
+		}
+		sort.Float64s(values)
+		best = math.Max(best, values[len(values)/2])
+		if j == len(speeds) {
+			break
+		}
+	}
+	return best
+}
+
+// Returns the glide ratio of the distance (km) over the height lost (m), 0 when no height was lost
+func glideRatio(distance float64, heightLost float64) float64 {
+	if heightLost <= 0 {
+		return 0
+	}
+	return math.Round(distance*1000/heightLost*10) / 10
+}
+
+// Splits the flight in the glides between the thermals
+func detectGlides(fixes []fix, thermals []thermal) []glide {
+	glides := []glide{}
+	altitudes := varioAltitudes(fixes)
+
+	// The fixes between the thermals, found back by their time
+	from := 0
+	add := func(to int) {
+		if to <= from {
+			return
+		}
+		part := fixes[from : to+1]
+		duration := part[len(part)-1].Time.Sub(part[0].Time)
+		if duration <= 0 {
+			return
+		}
+		distance := 0.0
+		for i := 1; i < len(part); i++ {
+			distance += fixDistance(part[i-1], part[i])
+		}
+		lost := altitudes[from] - altitudes[to]
+		glides = append(glides, glide{
+			Start:        newFlightPoint(part[0]),
+			End:          newFlightPoint(part[len(part)-1]),
+			Duration:     int64(duration / time.Second),
+			Distance:     math.Round(distance*100) / 100,
+			HeightLost:   int64(math.Round(lost)),
+			GlideRatio:   glideRatio(distance, lost),
+			AverageSpeed: math.Round(distance/duration.Hours()*10) / 10,
+			MaxSpeed:     maxSustainedSpeed(part, maxSpeedWindow),
+		})
+	}
+
+	for _, t := range thermals {
+		entry, exit := fixIndex(fixes, t.Entry.Time), fixIndex(fixes, t.Exit.Time)
+		add(entry)
+		from = exit
+	}
+	add(len(fixes) - 1)
+	return glides
+}
+
+// Returns the index of the fix at the time, or of the first one after it
+func fixIndex(fixes []fix, t time.Time) int {
+	for i, f := range fixes {
+		if !f.Time.Before(t) {
+			return i
+		}
+	}
+	return len(fixes) - 1
+}
+
+// Computes the speed statistics of the fixes of a flight, airborne for the given time
+func computeSpeedStats(fixes []fix, airborne time.Duration, length float64) speedStats {
+	stats := speedStats{MaxSpeed: maxSustainedSpeed(fixes, maxSpeedWindow)}
+	if airborne > 0 {
+		stats.AverageSpeed = math.Round(length/airborne.Hours()*10) / 10
+	}
+
+	distance, lost := 0.0, 0.0
+	for _, g := range detectGlides(fixes, detectThermals(fixes)) {
+		distance += g.Distance
+		lost += float64(g.HeightLost)
+	}
+	stats.GlideRatio = glideRatio(distance, lost)
+	return stats
+}
+
+// Returns the statistics in the order of the track detail, they are also the fields of GET /api/track/<id>/<field>
+func (s speedStats) apiFields() []apiField {
+	formatSpeed := func(speed float64) string { return strconv.FormatFloat(speed, 'f', 1, 64) }
+	return []apiField{
+		{"max_speed", formatSpeed(s.MaxSpeed)},
+		{"average_speed", formatSpeed(s.AverageSpeed)},
+		{"glide_ratio", formatSpeed(s.GlideRatio)},
+	}
+}
+
+// Handles path: GET /api/track/<id>/glides
+// Returns the glides between the thermals, the ground speed of every segment and a summary of them
+func handlerGlides(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	data, ok := requestedTrackData(w, r)
+	if !ok {
+		return
+	}
+
+	fixes := data.Fixes
+	flight := detectFlight(fixes)
+	response := struct {
+		Summary speedStats   `json:"summary"`
+		Glides  []glide      `json:"glides"`
+		Speeds  []speedPoint `json:"speeds"`
+	}{Glides: []glide{}, Speeds: []speedPoint{}}
+	if flight.Takeoff >= 0 {
+		flown := fixes[flight.Takeoff : flight.Landing+1]
+		response.Summary = computeSpeedStats(flown, flight.AirborneTime, flight.Length)
+		response.Glides = detectGlides(flown, detectThermals(flown))
+		response.Speeds = segmentSpeeds(flown)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
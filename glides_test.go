@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_maxSustainedSpeed(t *testing.T) {
+	fixes := moveNorth(nil, 2*time.Minute, 36, 0)
+	fixes = moveNorth(fixes, time.Minute, 72, 0)
+	if speed := maxSustainedSpeed(fixes, maxSpeedWindow); math.Abs(speed-72) > 0.5 {
+		t.Errorf("Expected a top speed of 72 km/h, got %f", speed)
+	}
+
+	// A GPS jump of 1 km and back
+	fixes = moveNorth(nil, 2*time.Minute, 36, 0)
+	fixes[60].Lon += 0.013
+	if speed := maxSustainedSpeed(fixes, maxSpeedWindow); math.Abs(speed-36) > 0.5 {
+		t.Errorf("Expected the GPS jump to be ignored, got %f", speed)
+	}
+}
+
+func Test_glideRatio(t *testing.T) {
+	if ratio := glideRatio(3, 300); ratio != 10 {
+		t.Errorf("Expected a glide ratio of 10, got %f", ratio)
+	}
+	if ratio := glideRatio(3, -50); ratio != 0 {
+		t.Errorf("Expected no glide ratio when climbing, got %f", ratio)
+	}
+}
+
+func Test_detectGlides(t *testing.T) {
+	fixes := moveNorth(nil, 5*time.Minute, 36, -1) // 3 km losing 300 m
+	fixes = fly(fixes, 3*time.Minute, 14.4, 35, 2) // Thermalling
+	fixes = fly(fixes, 5*time.Minute, 0, 54, -1.5) // 4.5 km losing 450 m
+
+	thermals := detectThermals(fixes)
+	glides := detectGlides(fixes, thermals)
+	if len(thermals) != 1 || len(glides) != 2 {
+		t.Fatalf("Expected 2 glides around the thermal, got %d glides and %d thermals", len(glides), len(thermals))
+	}
+
+	first, second := glides[0], glides[1]
+	if math.Abs(first.Distance-3) > 0.05 || math.Abs(float64(first.HeightLost)-300) > 5 || math.Abs(first.GlideRatio-10) > 0.3 {
+		t.Errorf("Expected 3 km at 10:1, got %+v", first)
+	}
+	if math.Abs(first.AverageSpeed-36) > 0.5 || math.Abs(first.MaxSpeed-36) > 0.5 {
+		t.Errorf("Expected the first glide at 36 km/h, got %+v", first)
+	}
+	if math.Abs(second.AverageSpeed-54) > 0.5 || math.Abs(second.GlideRatio-10) > 0.3 || !second.End.Time.Equal(fixes[len(fixes)-1].Time) {
+		t.Errorf("Expected the second glide at 54 km/h and 10:1 until the end, got %+v", second)
+	}
+
+	stats := computeSpeedStats(fixes, fixes[len(fixes)-1].Time.Sub(fixes[0].Time), 9)
+	if math.Abs(stats.MaxSpeed-54) > 0.5 || stats.AverageSpeed != 41.5 || math.Abs(stats.GlideRatio-10) > 0.3 {
+		t.Errorf("Unexpected speed statistics %+v", stats)
+	}
+}
+
+func Test_handlerGlides(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+	track, _, _ := tracksDB.TrackByID(context.Background(), "1")
+
+	router := newRouter()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/glides", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	var response struct {
+		Summary speedStats   `json:"summary"`
+		Glides  []glide      `json:"glides"`
+		Speeds  []speedPoint `json:"speeds"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding the glides, %s", err)
+	}
+	if response.Summary != track.Speed || len(response.Glides) == 0 || len(response.Speeds) == 0 {
+		t.Errorf("Expected the stored summary %+v, glides and speeds, got %+v with %d glides", track.Speed, response.Summary, len(response.Glides))
+	}
+
+	// The summary is in the track detail and the fields
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1", nil))
+	if !strings.Contains(rec.Body.String(), "\"glide_ratio\":\""+track.Speed.apiFields()[2].Value+"\"") {
+		t.Errorf("Expected the glide ratio in the track, got %s", rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/max_speed", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != track.Speed.apiFields()[0].Value {
+		t.Errorf("Expected the max speed field, got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// *** GPX EXPORT *** //
+
+// The elements of a GPX 1.1 document, only the ones the export writes
+type gpxDocument struct {
+	XMLName        xml.Name    `xml:"gpx"`
+	Version        string      `xml:"version,attr"`
+	Creator        string      `xml:"creator,attr"`
+	Namespace      string      `xml:"xmlns,attr"`
+	XSI            string      `xml:"xmlns:xsi,attr"`
+	SchemaLocation string      `xml:"xsi:schemaLocation,attr"`
+	Metadata       gpxMetadata `xml:"metadata"`
+	Track          gpxTrack    `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name   string     `xml:"name"`
+	Desc   string     `xml:"desc,omitempty"`
+	Author *gpxPerson `xml:"author,omitempty"`
+	Time   string     `xml:"time,omitempty"`
+	Bounds *gpxBounds `xml:"bounds,omitempty"`
+}
+
+type gpxPerson struct {
+	Name string `xml:"name"`
+}
+
+type gpxBounds struct {
+	MinLat string `xml:"minlat,attr"`
+	MinLon string `xml:"minlon,attr"`
+	MaxLat string `xml:"maxlat,attr"`
+	MaxLon string `xml:"maxlon,attr"`
+}
+
+type gpxTrack struct {
+	Name     string       `xml:"name"`
+	Src      string       `xml:"src,omitempty"`  // The recorder
+	Type     string       `xml:"type,omitempty"` // The competition class, the service takes the files of any aircraft
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat       string `xml:"lat,attr"`
+	Lon       string `xml:"lon,attr"`
+	Elevation int64  `xml:"ele"` // m
+	Time      string `xml:"time"`
+}
+
+// Formats a coordinate in degrees, the IGC files are precise to the thousandth of a minute
+func formatDegrees(degrees float64) string {
+	return strconv.FormatFloat(degrees, 'f', 6, 64)
+}
+
+func formatGPXTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Returns the name of the exported track, eg. "Dijon Planeurs CDVV 2017-08-09"
+func exportName(track tracks, fixes []fix) string {
+	name := strings.TrimSpace(track.Pilot)
+	if name == "" {
+		name = "Track " + track.UniqueID
+	}
+	if len(fixes) > 0 {
+		name += " " + fixes[0].Time.UTC().Format("2006-01-02")
+	}
+	return name
+}
+
+// Returns the glider and its registration, eg. "DG 500 (F-CIED)"
+func exportGlider(track tracks) string {
+	glider := strings.TrimSpace(track.Glider)
+	if id := strings.TrimSpace(track.GliderID); id != "" {
+		if glider == "" {
+			return id
+		}
+		glider += " (" + id + ")"
+	}
+	return glider
+}
+
+// Builds the GPX document of the track, with a track segment for every flight of the fixes, see splitFlights
+func newGPXDocument(track tracks, fixes []fix) gpxDocument {
+	doc := gpxDocument{
+		Version:        "1.1",
+		Creator:        "paragliding",
+		Namespace:      "http://www.topografix.com/GPX/1/1",
+		XSI:            "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: "http://www.topografix.com/GPX/1/1 http://www.topografix.com/GPX/1/1/gpx.xsd",
+		Metadata:       gpxMetadata{Name: exportName(track, fixes)},
+		Track: gpxTrack{
+			Name:     exportName(track, fixes),
+			Src:      strings.TrimSpace(track.Header.RecorderType),
+			Type:     strings.TrimSpace(track.Header.CompetitionClass),
+			Segments: []gpxSegment{},
+		},
+	}
+	if glider := exportGlider(track); glider != "" {
+		doc.Metadata.Desc = "Glider: " + glider
+	}
+	if pilot := strings.TrimSpace(track.Pilot); pilot != "" {
+		doc.Metadata.Author = &gpxPerson{Name: pilot}
+	}
+	if len(fixes) == 0 {
+		return doc
+	}
+
+	flights := splitFlights(fixes)
+	first := flights[0][0]
+	doc.Metadata.Time = formatGPXTime(first.Time)
+	minLat, minLon, maxLat, maxLon := first.Lat, first.Lon, first.Lat, first.Lon
+	for _, flight := range flights {
+		segment := gpxSegment{Points: make([]gpxPoint, 0, len(flight))}
+		for _, f := range flight {
+			segment.Points = append(segment.Points, gpxPoint{
+				Lat:       formatDegrees(f.Lat),
+				Lon:       formatDegrees(f.Lon),
+				Elevation: f.altitude(),
+				Time:      formatGPXTime(f.Time),
+			})
+			if f.Lat < minLat {
+				minLat = f.Lat
+			}
+			if f.Lat > maxLat {
+				maxLat = f.Lat
+			}
+			if f.Lon < minLon {
+				minLon = f.Lon
+			}
+			if f.Lon > maxLon {
+				maxLon = f.Lon
+			}
+		}
+		doc.Track.Segments = append(doc.Track.Segments, segment)
+	}
+	doc.Metadata.Bounds = &gpxBounds{
+		MinLat: formatDegrees(minLat), MinLon: formatDegrees(minLon),
+		MaxLat: formatDegrees(maxLat), MaxLon: formatDegrees(maxLon),
+	}
+	return doc
+}
+
+// Writes the track as a GPX 1.1 document, the elevation is the GPS altitude, or the pressure altitude when the GPS had none
+func writeGPX(w io.Writer, track tracks, data trackData, options url.Values) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(newGPXDocument(track, data.Fixes)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	igc "github.com/marni/goigc"
+)
+
+// *** IGC HEADER *** //
+
+// trackHeader is the part of the A-record and H-records of the file that is not stored elsewhere on the track
+// The pilot, the glider and the date are kept in their own fields of the track
+type trackHeader struct {
+	Manufacturer     string // Three letter code from the A-record, eg. FLA
+	RecorderID       string // Serial number of the recorder, from the A-record
+	RecorderType     string // Make and model of the recorder
+	FirmwareVersion  string
+	HardwareVersion  string
+	GPSReceiver      string // Make and model of the GPS receiver
+	PressureSensor   string // Make and model of the pressure sensor
+	CompetitionID    string
+	CompetitionClass string
+	GPSDatum         string // Always WGS84 for the approved recorders
+	Timezone         int    // Hours from UTC of the local time
+	FixAccuracy      int64  // m, as declared by the recorder
+	Crew             string // The second pilot of a tandem
+}
+
+// Copies the header parsed from the file, without the spaces the recorders pad the values with
+func newTrackHeader(header igc.Header) trackHeader {
+	return trackHeader{
+		Manufacturer:     strings.TrimSpace(header.Manufacturer),
+		RecorderID:       strings.TrimSpace(header.UniqueID),
+		RecorderType:     strings.TrimSpace(header.FlightRecorder),
+		FirmwareVersion:  strings.TrimSpace(header.FirmwareVersion),
+		HardwareVersion:  strings.TrimSpace(header.HardwareVersion),
+		GPSReceiver:      strings.TrimSpace(header.GPS),
+		PressureSensor:   strings.TrimSpace(header.PressureSensor),
+		CompetitionID:    strings.TrimSpace(header.CompetitionID),
+		CompetitionClass: strings.TrimSpace(header.CompetitionClass),
+		GPSDatum:         strings.TrimSpace(header.GPSDatum),
+		Timezone:         header.Timezone,
+		FixAccuracy:      header.FixAccuracy,
+		Crew:             strings.TrimSpace(header.Crew),
+	}
+}
+
+// Returns the header in the order of the track detail, they are also the fields of GET /api/track/<id>/<field>
+func (h trackHeader) apiFields() []apiField {
+	return []apiField{
+		{"manufacturer", h.Manufacturer},
+		{"recorder_id", h.RecorderID},
+		{"recorder_type", h.RecorderType},
+		{"firmware_version", h.FirmwareVersion},
+		{"hardware_version", h.HardwareVersion},
+		{"gps_receiver", h.GPSReceiver},
+		{"pressure_sensor", h.PressureSensor},
+		{"competition_id", h.CompetitionID},
+		{"competition_class", h.CompetitionClass},
+		{"gps_datum", h.GPSDatum},
+		{"timezone", strconv.Itoa(h.Timezone)},
+		{"fix_accuracy", strconv.FormatInt(h.FixAccuracy, 10)},
+		{"crew", h.Crew},
+	}
+}
+
+// Sets the values read from the file itself on the track: the header, the declared task and the validation
+func (track *tracks) setFileValues(parsed igc.Track, validation fileValidation) {
+	track.Header = newTrackHeader(parsed.Header)
+	track.Task = newDeclaredTask(parsed.Task)
+	track.ValidationStatus, track.IGCApproved = validation.Status, validation.Approved
+}
+
+// Returns every field of the track detail after the fixed ones, the header and then the statistics
+func (track tracks) apiFields() []apiField {
+	return append(track.Header.apiFields(), track.statsFields()...)
+}
+
+// Encodes the value as a JSON string, the header values are written by the recorder and can have quotes
+func jsonString(value string) string {
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_handlerID_Header(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	router := newRouter()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1", nil))
+	detail := map[string]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("Expected the track detail to be valid JSON, got %s: %s", err, rec.Body.String())
+	}
+
+	expected := map[string]string{
+		"manufacturer":     "FLA",
+		"recorder_id":      "5HH",
+		"recorder_type":    "Flarm-IGC",
+		"firmware_version": "Flarm-IGC06.09",
+		"hardware_version": "Flarm-IGC06",
+		"pressure_sensor":  "Intersema MS5534B,8191",
+		"gps_datum":        "WGS84",
+		"fix_accuracy":     "500",
+		"crew":             "Dijon Planeurs CDVV",
+		"competition_id":   "",
+	}
+	for field, value := range expected {
+		if detail[field] != value {
+			t.Errorf("%s: expected %q in the detail, got %q", field, value, detail[field])
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/"+field, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != value {
+			t.Errorf("%s: expected %q, got %d %q", field, value, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func Test_handlerID_HeaderQuotes(t *testing.T) {
+	useMemoryTracks(t)
+	content := encodeIGC(moveNorth(nil, time.Minute, 30, 0), "HFCIDCompetitionID:\"X1\"", "HFCCLCompetitionClass:Sport", "HFTZNTimezone:2")
+	postTrack(t, "application/octet-stream", content)
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1", nil))
+	detail := map[string]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("Expected the quotes to be escaped, got %s: %s", err, rec.Body.String())
+	}
+	if detail["competition_id"] != `"X1"` || detail["competition_class"] != "Sport" || detail["timezone"] != "2" {
+		t.Errorf("Unexpected header in the detail, %v", detail)
+	}
+}
+
+func Test_handlerID_Escaping(t *testing.T) {
+	useMemoryTracks(t)
+	track := tracks{UniqueID: "1", Pilot: `Jean "Le Vautour" Dupont`, Glider: `Ozone\Enzo`, GliderID: "F-\tCIED",
+		Hdate: "2017-08-09", URL: `http://example.com/track.igc?name="x"`}
+	tracksDB.InsertTrack(context.Background(), track)
+
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1", nil))
+	detail := map[string]string{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("Expected the track detail to be valid JSON, got %s: %s", err, rec.Body.String())
+	}
+	for field, value := range map[string]string{"pilot": track.Pilot, "glider": track.Glider, "glider_id": track.GliderID,
+		"H_date": track.Hdate, "track_src_url": track.URL} {
+		if detail[field] != value {
+			t.Errorf("%s: expected %q in the detail, got %q", field, value, detail[field])
+		}
+	}
+}
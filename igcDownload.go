@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// *** IGC DOWNLOAD *** //
+
+// The content type of the IGC files, as the FAI uses it
+const igcContentType = "application/vnd.fai.igc"
+
+// Returns the name of the downloaded file from the pilot and the date of the flight, eg. Dijon-Planeurs-CDVV-2017-08-09.igc
+func igcFileName(track tracks, fixes []fix) string {
+	pilot := strings.Trim(regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(track.Pilot, "-"), "-")
+	if pilot == "" {
+		pilot = "track-" + track.UniqueID
+	}
+	if len(fixes) > 0 {
+		pilot += "-" + fixes[0].Time.UTC().Format("2006-01-02")
+	}
+	return pilot + ".igc"
+}
+
+// Returns true when the If-None-Match header lists the ETag, the comparison is weak as for GET requests
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Handles path: GET /api/track/<id>/igc
+// Returns the IGC file exactly as it was uploaded, so its G-record can still be checked, with the hash of the file as its ETag
+// A request with that ETag in If-None-Match is answered 304, without reading the file
+func handlerIGC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if !regexp.MustCompile(`^[0-9]+$`).MatchString(id) {
+		http.Error(w, "400 - Bad Request", http.StatusBadRequest)
+		return
+	}
+	track, found, err := tracksDB.TrackByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "404 - The trackInfo with that id doesn't exists in our database ", http.StatusNotFound)
+		return
+	}
+
+	// The hash is the one the duplicates are found with, the tracks stored before it was kept have no ETag
+	if track.ContentHash != "" {
+		etag := `"` + track.ContentHash + `"`
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	data, ok := requestedTrackData(w, r)
+	if !ok {
+		return
+	}
+	content, err := data.rawIGC()
+	if err != nil {
+		http.Error(w, "500 - Could not read the IGC file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", igcContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+igcFileName(track, data.Fixes)+`"`)
+	w.Write(content)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Gets the IGC file of the track, with the If-None-Match header when it is not empty
+func getIGC(t *testing.T, id, ifNoneMatch string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/paragliding/api/track/"+id+"/igc", nil)
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	rec := httptest.NewRecorder()
+	newRouter().ServeHTTP(rec, req)
+	return rec
+}
+
+func Test_handlerIGC(t *testing.T) {
+	useMemoryTracks(t)
+	content := readSampleIGC(t)
+	postTrack(t, "application/octet-stream", content)
+
+	rec := getIGC(t, "1", "")
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), content) {
+		t.Fatalf("Expected the file exactly as uploaded, got %d and %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec.Header().Get("Content-Type") != igcContentType {
+		t.Errorf("Expected the content type %s, got %s", igcContentType, rec.Header().Get("Content-Type"))
+	}
+	if disposition := rec.Header().Get("Content-Disposition"); disposition != `attachment; filename="Dijon-Planeurs-CDVV-2017-08-09.igc"` {
+		t.Errorf("Expected the file name from the pilot and the date, got %s", disposition)
+	}
+
+	etag := rec.Header().Get("ETag")
+	if etag != `"`+contentHash(content)+`"` {
+		t.Fatalf("Expected the hash of the file as the ETag, got %s", etag)
+	}
+	for _, ifNoneMatch := range []string{etag, `"other", ` + etag, "W/" + etag, "*"} {
+		if rec := getIGC(t, "1", ifNoneMatch); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+			t.Errorf("Expected StatusNotModified %d without the file for %s, got %d", http.StatusNotModified, ifNoneMatch, rec.Code)
+		}
+	}
+	if rec := getIGC(t, "1", `"other"`); rec.Code != http.StatusOK {
+		t.Errorf("Expected the file for another ETag, got %d", rec.Code)
+	}
+
+	// The 304 is answered from the stored hash, without reading the file
+	tracksDB.InsertTrack(context.Background(), tracks{UniqueID: "3", ContentHash: "abc"})
+	if rec := getIGC(t, "3", `"abc"`); rec.Code != http.StatusNotModified {
+		t.Errorf("Expected StatusNotModified %d from the stored hash, got %d", http.StatusNotModified, rec.Code)
+	}
+	if rec := getIGC(t, "3", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected StatusNotFound %d for a track without its file, got %d", http.StatusNotFound, rec.Code)
+	}
+	// Stored before the hashes were kept
+	tracksDB.InsertTrack(context.Background(), tracks{UniqueID: "4"})
+	if rec := getIGC(t, "4", "*"); rec.Code != http.StatusNotFound || rec.Header().Get("ETag") != "" {
+		t.Errorf("Expected no ETag without the hash, got %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	if rec := getIGC(t, "2", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected StatusNotFound %d for an unknown track, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := getIGC(t, "abc", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest %d for a wrong id, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func Test_igcFileName(t *testing.T) {
+	fixes := moveNorth(nil, time.Minute, 30, 0)
+	tests := []struct {
+		track tracks
+		fixes []fix
+		want  string
+	}{
+		{tracks{UniqueID: "1", Pilot: "Jane Doe"}, fixes, "Jane-Doe-2018-07-14.igc"},
+		{tracks{UniqueID: "1", Pilot: ` "Ann/O'Neil" `}, fixes, "Ann-O-Neil-2018-07-14.igc"},
+		{tracks{UniqueID: "7"}, fixes, "track-7-2018-07-14.igc"},
+		{tracks{UniqueID: "7", Pilot: "Jane"}, nil, "Jane.igc"},
+	}
+	for _, test := range tests {
+		if got := igcFileName(test.track, test.fixes); got != test.want {
+			t.Errorf("Expected %s for %q, got %s", test.want, test.track.Pilot, got)
+		}
+	}
+}
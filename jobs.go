@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// *** INGESTION JOBS *** //
+
+// Status of an ingestion job
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+// errQueueFull is returned when the queue can't take another job
+var errQueueFull = errors.New("the ingestion queue is full")
+
+// job is an IGC file being registered in the background
+type job struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	URL       string    `json:"url,omitempty"`
+	TrackID   string    `json:"track_id,omitempty"`
+	Flights   []string  `json:"flights,omitempty"`   // IDs of every flight when the file was split, TrackID is the first one
+	Duplicate bool      `json:"duplicate,omitempty"` // The file was already stored, TrackID is the stored track
+	Error     string    `json:"error,omitempty"`
+	Created   time.Time `json:"created"`
+	Updated   time.Time `json:"updated"`
+}
+
+// jobTask registers the track of a job
+type jobTask func(ctx context.Context) (tracks, bool, error)
+
+type queuedJob struct {
+	id   string
+	task jobTask
+}
+
+// jobQueue runs the ingestion jobs on a fixed number of workers
+// The jobs are only kept in memory, they are lost when the service restarts
+type jobQueue struct {
+	mu        sync.RWMutex
+	jobs      map[string]*job
+	queue     chan queuedJob
+	cfg       JobsConfig
+	startOnce sync.Once
+	closed    bool
+	workers   sync.WaitGroup
+}
+
+// The queue used by the handlers, main() replaces it with one using the configured sizes
+var ingestJobs = newJobQueue(config.Jobs)
+
+func newJobQueue(cfg JobsConfig) *jobQueue {
+	return &jobQueue{
+		jobs:  map[string]*job{},
+		queue: make(chan queuedJob, cfg.QueueSize),
+		cfg:   cfg,
+	}
+}
+
+// Starts the workers, on the first job so a queue that is never used has no goroutines
+func (q *jobQueue) start() {
+	q.startOnce.Do(func() {
+		for i := 0; i < q.cfg.Workers; i++ {
+			q.workers.Add(1)
+			go q.work()
+		}
+	})
+}
+
+func (q *jobQueue) work() {
+	defer q.workers.Done()
+	for queued := range q.queue {
+		q.run(queued)
+	}
+}
+
+// Runs the task of the job and records its outcome
+func (q *jobQueue) run(queued queuedJob) {
+	q.update(queued.id, func(j *job) { j.Status = jobRunning })
+
+	// The request that queued the job is gone, the job gets its own deadline
+	ctx, cancel := context.WithTimeout(context.Background(), q.cfg.Timeout)
+	defer cancel()
+
+	track, duplicate, err := queued.task(ctx)
+
+	q.update(queued.id, func(j *job) {
+		if err != nil {
+			j.Status = jobFailed
+			j.Error = err.Error()
+			return
+		}
+		j.Status = jobSucceeded
+		j.TrackID = track.UniqueID
+		j.Flights = track.Flights
+		j.Duplicate = duplicate
+	})
+
+	if err == nil && !duplicate {
+		triggerWhenTrackIsAdded(track.fileTrackIDs())
+	}
+}
+
+func (q *jobQueue) update(id string, change func(j *job)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if j, ok := q.jobs[id]; ok {
+		change(j)
+		j.Updated = time.Now()
+	}
+}
+
+// Adds a job to the queue, or returns errQueueFull without waiting
+func (q *jobQueue) enqueue(srcURL string, task jobTask) (job, error) {
+	q.start()
+
+	id, err := newJobID()
+	if err != nil {
+		return job{}, err
+	}
+	now := time.Now()
+	j := &job{ID: id, Status: jobQueued, URL: srcURL, Created: now, Updated: now}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return job{}, errQueueFull
+	}
+
+	q.forgetOldJobs(now)
+
+	select {
+	case q.queue <- queuedJob{id: id, task: task}:
+	default:
+		return job{}, errQueueFull
+	}
+	q.jobs[id] = j
+	return *j, nil
+}
+
+// Removes the finished jobs older than the retention, must be called with the lock held
+func (q *jobQueue) forgetOldJobs(now time.Time) {
+	for id, j := range q.jobs {
+		if (j.Status == jobSucceeded || j.Status == jobFailed) && now.Sub(j.Updated) > q.cfg.Retention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// Returns the job with the given ID, and false if there is none
+func (q *jobQueue) job(id string) (job, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	j, ok := q.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// Stops taking jobs and waits for the queued ones to finish, or until ctx is done
+func (q *jobQueue) shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.queue)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Job IDs are random, so they can't be guessed to read the jobs of other clients
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Returns true when the client asked for the track to be registered in the background,
+// with ?async=true or the header "Prefer: respond-async"
+func wantsAsync(r *http.Request) bool {
+	async := r.URL.Query().Get("async")
+	return async == "true" || async == "1" || strings.Contains(r.Header.Get("Prefer"), "respond-async")
+}
+
+// Queues the task and answers 202 Accepted with the job, or 503 if the queue is full
+func respondQueuedJob(w http.ResponseWriter, srcURL string, task jobTask) {
+	j, err := ingestJobs.enqueue(srcURL, task)
+	if err == errQueueFull {
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, "503 - Service Unavailable, "+err.Error()+", try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, "500 - Could not create the job", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/paragliding/api/jobs/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j)
+}
+
+// Handles path: GET /api/jobs/<id>
+// Returns the status of the ingestion job, with the track ID once it succeeded or the error if it failed
+func handlerJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	j, found := ingestJobs.job(mux.Vars(r)["id"])
+	if !found {
+		http.Error(w, "404 - The job with that id doesn't exist, or it finished long ago", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j)
+}
+
+// Logs the jobs that were still queued when the service stopped
+func (q *jobQueue) logUnfinished() {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	for _, j := range q.jobs {
+		if j.Status == jobQueued || j.Status == jobRunning {
+			log.Printf("Job %s (%s) did not finish before the service stopped", j.ID, j.URL)
+		}
+	}
+}
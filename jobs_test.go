@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Replaces the ingestion queue with a new one for the duration of the test
+func useJobQueue(t *testing.T, cfg JobsConfig) *jobQueue {
+	previous := ingestJobs
+	ingestJobs = newJobQueue(cfg)
+	t.Cleanup(func() {
+		ingestJobs.shutdown(context.Background())
+		ingestJobs = previous
+	})
+	return ingestJobs
+}
+
+// Polls the job until it is finished
+func waitForJob(t *testing.T, q *jobQueue, id string) job {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		j, found := q.job(id)
+		if !found {
+			t.Fatalf("Job %s not found", id)
+		}
+		if j.Status == jobSucceeded || j.Status == jobFailed {
+			return j
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not finish", id)
+	return job{}
+}
+
+func Test_jobQueue(t *testing.T) {
+	q := useJobQueue(t, config.Jobs)
+
+	ok, err := q.enqueue("http://example.com/ok.igc", func(ctx context.Context) (tracks, bool, error) {
+		return tracks{UniqueID: "7"}, true, nil
+	})
+	if err != nil || ok.Status != jobQueued {
+		t.Fatalf("Expected a queued job, got %+v %v", ok, err)
+	}
+	failed, _ := q.enqueue("", func(ctx context.Context) (tracks, bool, error) {
+		return tracks{}, false, errors.New("broken file")
+	})
+
+	if j := waitForJob(t, q, ok.ID); j.Status != jobSucceeded || j.TrackID != "7" || !j.Duplicate {
+		t.Errorf("Expected the job to succeed with the duplicate track 7, got %+v", j)
+	}
+	if j := waitForJob(t, q, failed.ID); j.Status != jobFailed || j.Error != "broken file" {
+		t.Errorf("Expected the job to fail, got %+v", j)
+	}
+}
+
+func Test_jobQueue_Full(t *testing.T) {
+	q := useJobQueue(t, JobsConfig{Workers: 1, QueueSize: 1, Timeout: time.Second, Retention: time.Hour})
+
+	release := make(chan struct{})
+	blocking := func(ctx context.Context) (tracks, bool, error) {
+		<-release
+		return tracks{}, false, errors.New("released")
+	}
+
+	// One job running and one waiting, the third one doesn't fit
+	first, _ := q.enqueue("", blocking)
+	for j, _ := q.job(first.ID); j.Status != jobRunning; j, _ = q.job(first.ID) {
+		time.Sleep(time.Millisecond)
+	}
+	q.enqueue("", blocking)
+	if _, err := q.enqueue("", blocking); err != errQueueFull {
+		t.Errorf("Expected errQueueFull, got %v", err)
+	}
+	close(release)
+
+	if err := q.shutdown(context.Background()); err != nil {
+		t.Errorf("Expected the queue to drain, got %v", err)
+	}
+	if _, err := q.enqueue("", blocking); err != errQueueFull {
+		t.Errorf("Expected the closed queue to refuse jobs, got %v", err)
+	}
+}
+
+func Test_handlerTrack_Async(t *testing.T) {
+	useMemoryTracks(t)
+	q := useJobQueue(t, config.Jobs)
+
+	req := httptest.NewRequest(http.MethodPost, "/paragliding/api/track?async=true", bytes.NewReader(readSampleIGC(t)))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	rec := httptest.NewRecorder()
+	handlerTrack(rec, req)
+
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected StatusAccepted %d, got %d %s", http.StatusAccepted, rec.Code, rec.Body)
+	}
+	queued := job{}
+	json.NewDecoder(rec.Body).Decode(&queued)
+	if rec.Header().Get("Location") != "/paragliding/api/jobs/"+queued.ID {
+		t.Errorf("Expected the location of the job, got %q", rec.Header().Get("Location"))
+	}
+
+	waitForJob(t, q, queued.ID)
+
+	// Reading the job through the API
+	router := mux.NewRouter()
+	router.HandleFunc("/paragliding/api/jobs/{id}", handlerJob)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/jobs/"+queued.ID, nil))
+
+	finished := job{}
+	json.NewDecoder(rec.Body).Decode(&finished)
+	if rec.Code != http.StatusOK || finished.Status != jobSucceeded || finished.TrackID != "1" {
+		t.Errorf("Expected the job to succeed with track 1, got %d %+v", rec.Code, finished)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/jobs/unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected StatusNotFound for an unknown job, got %d", rec.Code)
+	}
+}
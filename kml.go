@@ -0,0 +1,226 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"io"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// *** KML AND KMZ EXPORT *** //
+
+// The climb rate is averaged over this window to colour the track, the rate between two fixes is too noisy
+const kmlClimbWindow = 10 * time.Second
+
+// climbColours are the colours of the track by climb rate, in the aabbggrr order of KML,
+// from strong sink in blue to strong climb in red
+var climbColours = []struct {
+	Below  float64 // m/s, the colour is used for the rates below this and above the previous one
+	Colour string
+}{
+	{-2.5, "ffff0000"}, // Blue
+	{-1, "ffffff00"},   // Cyan
+	{0, "ff00ff00"},    // Green
+	{1, "ff00ffff"},    // Yellow
+	{2.5, "ff0080ff"},  // Orange
+	{math.Inf(1), "ff0000ff"},
+}
+
+// The elements of a KML 2.2 document, only the ones the export writes
+type kmlDocument struct {
+	XMLName   xml.Name     `xml:"kml"`
+	Namespace string       `xml:"xmlns,attr"`
+	Document  kmlContainer `xml:"Document"`
+}
+
+type kmlContainer struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description,omitempty"`
+	TimeSpan    *kmlTimeSpan   `xml:"TimeSpan,omitempty"`
+	Styles      []kmlStyle     `xml:"Style"`
+	Folder      *kmlFolder     `xml:"Folder,omitempty"`
+	Placemarks  []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlFolder struct {
+	Name       string         `xml:"name"`
+	Placemarks []kmlPlacemark `xml:"Placemark"`
+}
+
+type kmlTimeSpan struct {
+	Begin string `xml:"begin"`
+	End   string `xml:"end"`
+}
+
+type kmlTimeStamp struct {
+	When string `xml:"when"`
+}
+
+type kmlStyle struct {
+	ID        string        `xml:"id,attr"`
+	LineStyle *kmlLineStyle `xml:"LineStyle,omitempty"`
+	PolyStyle *kmlPolyStyle `xml:"PolyStyle,omitempty"`
+}
+
+type kmlLineStyle struct {
+	Colour string `xml:"color"`
+	Width  int    `xml:"width"`
+}
+
+type kmlPolyStyle struct {
+	Colour string `xml:"color"` // Of the curtain down to the ground when the track is extruded
+}
+
+type kmlPlacemark struct {
+	Name        string         `xml:"name,omitempty"`
+	Description string         `xml:"description,omitempty"`
+	TimeSpan    *kmlTimeSpan   `xml:"TimeSpan,omitempty"`
+	TimeStamp   *kmlTimeStamp  `xml:"TimeStamp,omitempty"`
+	StyleURL    string         `xml:"styleUrl,omitempty"`
+	LineString  *kmlLineString `xml:"LineString,omitempty"`
+	Point       *kmlPoint      `xml:"Point,omitempty"`
+}
+
+type kmlLineString struct {
+	Extrude      int    `xml:"extrude,omitempty"`
+	AltitudeMode string `xml:"altitudeMode"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+type kmlPoint struct {
+	AltitudeMode string `xml:"altitudeMode"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+// Returns the KML coordinates of the fix: longitude, latitude and altitude in meters above the sea
+func kmlCoordinates(f fix) string {
+	return formatDegrees(f.Lon) + "," + formatDegrees(f.Lat) + "," + strconv.FormatInt(f.altitude(), 10)
+}
+
+// Returns the index in climbColours of the colour of the climb rate
+// An unknown rate, eg. of a track shorter than kmlClimbWindow, is coloured as level flight
+func climbColour(rate float64) int {
+	if math.IsNaN(rate) {
+		rate = 0
+	}
+	for i, colour := range climbColours {
+		if rate < colour.Below {
+			return i
+		}
+	}
+	return len(climbColours) - 1
+}
+
+// Returns the placemark of a fix, eg. the takeoff
+func kmlFixPlacemark(name string, f fix) kmlPlacemark {
+	return kmlPlacemark{
+		Name:        name,
+		Description: formatGPXTime(f.Time) + ", " + strconv.FormatInt(f.altitude(), 10) + " m",
+		TimeStamp:   &kmlTimeStamp{When: formatGPXTime(f.Time)},
+		Point:       &kmlPoint{AltitudeMode: "absolute", Coordinates: kmlCoordinates(f)},
+	}
+}
+
+// Builds the KML document of the track: the line of the fixes at their absolute altitude, cut in a placemark
+// every time the climb rate changes colour, each with its time span so the flight can be replayed,
+// and the placemarks of the takeoff and the landing. extrude draws the curtain from the line down to the ground
+func newKMLDocument(track tracks, fixes []fix, extrude bool) kmlDocument {
+	doc := kmlDocument{
+		Namespace: "http://www.opengis.net/kml/2.2",
+		Document:  kmlContainer{Name: exportName(track, fixes), Placemarks: []kmlPlacemark{}},
+	}
+	if glider := exportGlider(track); glider != "" {
+		doc.Document.Description = "Glider: " + glider
+	}
+	for i, colour := range climbColours {
+		doc.Document.Styles = append(doc.Document.Styles, kmlStyle{
+			ID:        "climb" + strconv.Itoa(i),
+			LineStyle: &kmlLineStyle{Colour: colour.Colour, Width: 3},
+			PolyStyle: &kmlPolyStyle{Colour: "7f" + colour.Colour[2:]},
+		})
+	}
+	if len(fixes) < 2 {
+		return doc
+	}
+
+	doc.Document.TimeSpan = &kmlTimeSpan{Begin: formatGPXTime(fixes[0].Time), End: formatGPXTime(fixes[len(fixes)-1].Time)}
+	folder := &kmlFolder{Name: "Track", Placemarks: []kmlPlacemark{}}
+	rates := windowedClimbRates(fixes, varioAltitudes(fixes), kmlClimbWindow)
+
+	colours := make([]int, len(fixes)-1) // colours[i] is the segment from i to i+1
+	for i := range colours {
+		if math.IsNaN(rates[i]) && i > 0 {
+			// The end of the track is shorter than the window
+			colours[i] = colours[i-1]
+			continue
+		}
+		colours[i] = climbColour(rates[i])
+	}
+
+	from := 0
+	for i := 1; i <= len(colours); i++ {
+		if i < len(colours) && colours[i] == colours[from] {
+			continue
+		}
+		// The fixes from, ..., i have the same colour, the next line starts at the last fix of this one
+		coordinates := make([]string, 0, i-from+1)
+		for _, f := range fixes[from : i+1] {
+			coordinates = append(coordinates, kmlCoordinates(f))
+		}
+		line := &kmlLineString{AltitudeMode: "absolute", Coordinates: strings.Join(coordinates, " ")}
+		if extrude {
+			line.Extrude = 1
+		}
+		folder.Placemarks = append(folder.Placemarks, kmlPlacemark{
+			TimeSpan:   &kmlTimeSpan{Begin: formatGPXTime(fixes[from].Time), End: formatGPXTime(fixes[i].Time)},
+			StyleURL:   "#climb" + strconv.Itoa(colours[from]),
+			LineString: line,
+		})
+		from = i
+	}
+	doc.Document.Folder = folder
+
+	if !track.TakeoffTime.IsZero() {
+		doc.Document.Placemarks = append(doc.Document.Placemarks,
+			kmlFixPlacemark("Takeoff", fixes[fixIndex(fixes, track.TakeoffTime)]),
+			kmlFixPlacemark("Landing", fixes[fixIndex(fixes, track.LandingTime)]))
+	}
+	return doc
+}
+
+// Returns true when the export option is set to true, eg. ?extrude=true
+func exportOption(options url.Values, name string) bool {
+	value, _ := strconv.ParseBool(options.Get(name))
+	return value
+}
+
+// Writes the track as a KML 2.2 document for Google Earth, ?extrude=true draws the curtain down to the ground
+func writeKML(w io.Writer, track tracks, data trackData, options url.Values) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(newKMLDocument(track, data.Fixes, exportOption(options, "extrude"))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Writes the KML document zipped, as doc.kml, which is what Google Earth opens in a KMZ file
+func writeKMZ(w io.Writer, track tracks, data trackData, options url.Values) error {
+	archive := zip.NewWriter(w)
+	file, err := archive.Create("doc.kml")
+	if err != nil {
+		return err
+	}
+	if err := writeKML(file, track, data, options); err != nil {
+		return err
+	}
+	return archive.Close()
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http" //"html/template"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time" //"path/filepath"
 
 	"github.com/gorilla/mux"
@@ -24,6 +27,22 @@ type tracks struct {
 	Hdate        string
 	URL          string
 	TimeRecorded time.Time
+	ContentHash  string       // SHA-256 of the IGC file, to find files that were already uploaded
+	Header       trackHeader  // The rest of the A-record and H-records of the file
+	Task         declaredTask // Declared in the C-records of the file, empty when there is none
+	Flights      []string     // IDs of every track split from the same file, in order, empty when it had a single flight
+	// Checked from the A-record and the G-record of the file, see validateIGC
+	ValidationStatus string
+	IGCApproved      bool
+	// Derived from the fixes, see analyseTrack
+	TakeoffTime     time.Time
+	LandingTime     time.Time
+	Duration        time.Duration
+	AirborneTime    time.Duration
+	Altitude        altitudeStats
+	Speed           speedStats
+	Score           trackScore // Cross-country score, see scoreFlight
+	AnalysisVersion int
 }
 
 //FloatToString : convert a float number to a string
@@ -71,14 +90,69 @@ func timeSince(t time.Time) string {
 	return fmt.Sprintf("P%dY%dD%dH%dM%d.%dS", y, d, h, m, s, f)
 }
 
-func main() {
+// Creates the storage backend selected in the configuration
+// "memory" keeps everything in memory, "bolt" uses a single file and "mongo" uses MongoDB
+func setupStores(cfg StorageConfig) error {
+	switch cfg.Backend {
+	case "memory":
+		store := newMemoryStore()
+		tracksDB, webhooksDB = store, store
+		return nil
+	case "bolt":
+		store, err := newBoltStore(cfg.Path)
+		if err != nil {
+			return err
+		}
+		tracksDB, webhooksDB = store, store
+		return nil
+	}
+
+	client, err := mongoConnect(cfg)
+	if err != nil {
+		return err
+	}
+	store := newMongoStore(client, cfg)
+	if err := store.setup(context.Background()); err != nil {
+		return err
+	}
+	tracksDB, webhooksDB = store, store
+	return nil
+}
+
+// Releases the connections or files held by the stores
+func closeStores(ctx context.Context) {
+	closed := map[interface{}]bool{}
+	for _, store := range []interface{}{tracksDB, webhooksDB} {
+		if c, ok := store.(storeCloser); ok && !closed[store] {
+			closed[store] = true
+			if err := c.Close(ctx); err != nil {
+				log.Println("Error closing the storage, ", err)
+			}
+		}
+	}
+}
+
+// Creates the router with every route of the service
+func newRouter() *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/paragliding", handler)
 	r.HandleFunc("/paragliding/api", handlerAPI)
 	//Handling Track
 	r.HandleFunc("/paragliding/api/track", handlerTrack)
+	r.HandleFunc("/paragliding/api/track/batch", handlerTrackBatch)
+	r.HandleFunc("/paragliding/api/track/geojson", handlerTracksGeoJSON)
+	r.HandleFunc("/paragliding/api/track/export", handlerTracksExport)
 	r.HandleFunc("/paragliding/api/track/{id}", handlerID)
+	r.HandleFunc("/paragliding/api/track/{id}/thermals", handlerThermals)
+	r.HandleFunc("/paragliding/api/track/{id}/score", handlerScore)
+	r.HandleFunc("/paragliding/api/track/{id}/task", handlerTask)
+	r.HandleFunc("/paragliding/api/track/{id}/glides", handlerGlides)
+	r.HandleFunc("/paragliding/api/track/{id}/points", handlerPoints)
+	r.HandleFunc("/paragliding/api/track/{id}/export", handlerExport)
+	r.HandleFunc("/paragliding/api/track/{id}/igc", handlerIGC)
 	r.HandleFunc("/paragliding/api/track/{id}/{field}", handlerField)
+	//Handling the ingestion jobs
+	r.HandleFunc("/paragliding/api/jobs/{id}", handlerJob)
 	//Handling ticker
 	r.HandleFunc("/paragliding/api/ticker/latest", handlerTickerLatest)
 	r.HandleFunc("/paragliding/api/ticker", handlerTicker)
@@ -87,16 +161,58 @@ func main() {
 	r.HandleFunc("/paragliding/api/webhook/new_track/", webhookNewTrack)
 	r.HandleFunc("/paragliding/api/webhook/new_track/{webhook_id}", webhookID)
 	//Handling the admin part
-	r.HandleFunc("/paragliding/admin/api/tracks_count", adminAPITracksCount)
-	r.HandleFunc("/paragliding/admin/api/tracks", adminAPITracks)
-	r.HandleFunc("/paragliding/admin/api/webhooks", adminAPIWebhookTrigger)
+	r.HandleFunc("/paragliding/admin/api/tracks_count", adminOnly(adminAPITracksCount))
+	r.HandleFunc("/paragliding/admin/api/tracks", adminOnly(adminAPITracks))
+	r.HandleFunc("/paragliding/admin/api/tracks/backfill", adminOnly(adminAPIBackfill))
+	r.HandleFunc("/paragliding/admin/api/webhooks", adminOnly(adminAPIWebhookTrigger))
 
-	err := http.ListenAndServe(":"+os.Getenv("PORT"), r)
+	return r
+}
+
+func main() {
+	var err error
+	config, err = loadConfig(os.Args[1:])
 	if err != nil {
-		log.Fatal("ListenAndServe: ", err)
+		log.Fatal(err)
 	}
 
-	/*if err := http.ListenAndServe(":8080", r); err != nil {
-		log.Fatal(err)
-	}*/
+	err = setupStores(config.Storage)
+	if err != nil {
+		log.Fatal("Storage: ", err)
+	}
+
+	ingestJobs = newJobQueue(config.Jobs)
+	igcFetcher = newFetcher(config.Fetch, config.Upload.MaxSize)
+
+	server := &http.Server{Addr: config.Listen, Handler: newRouter()}
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal("ListenAndServe: ", err)
+		}
+	}()
+
+	// Wait for SIGINT or SIGTERM (Heroku sends SIGTERM when stopping a dyno)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Println("Shutting down, waiting for the requests in progress")
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting connections and drain the in-flight requests and the queued jobs, then the webhooks they triggered
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("Error shutting down the server, ", err)
+	}
+	if err := ingestJobs.shutdown(ctx); err != nil {
+		ingestJobs.logUnfinished()
+	}
+	if err := waitForWebhooks(ctx); err != nil {
+		log.Println("Some webhooks were not delivered, ", err)
+	}
+
+	closeStores(ctx)
 }
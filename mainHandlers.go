@@ -4,16 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"math/rand"
+	"mime"
 	"net/http"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/gorilla/mux"
-	igc "github.com/marni/goigc"
 )
 
 //Handling based in parsing url
@@ -53,22 +50,37 @@ func handlerTrack(w http.ResponseWriter, r *http.Request) {
 	//Handling GET /paragliding/api/track for returning all ids storing in database
 	case http.MethodGet:
 
-		client := mongoConnect()
+		filter, problem := parseTrackFilter(r.URL.Query())
+		if problem != "" {
+			http.Error(w, "400 - Bad Request, "+problem, http.StatusBadRequest)
+			return
+		}
 
-		ids := getTrackID(client)
+		ids, err := listedTrackIDs(r.Context(), filter)
+		if err != nil {
+			http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+			return
+		}
 
-		fmt.Fprint(w, ids)
+		fmt.Fprint(w, "["+strings.Join(ids, ",")+"]")
 
 	case http.MethodPost:
 
+		// The IGC file itself can be sent instead of a URL
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == "application/octet-stream" || mediaType == "text/plain" || mediaType == "multipart/form-data" {
+			handlerTrackUpload(w, r, mediaType)
+			return
+		}
+
 		//handling post /igcinfo/api/igc for sending a url and returning an id for that url
 		pattern := ".*.igc"
 
 		URL := &_url{}
 
-		var error = json.NewDecoder(r.Body).Decode(URL)
-		if error != nil {
-			fmt.Fprintln(w, "Error!! ", error)
+		err := json.NewDecoder(r.Body).Decode(URL)
+		if err != nil {
+			fmt.Fprintln(w, "Error!! ", err)
 			return
 		}
 		res, err := regexp.MatchString(pattern, URL.URL)
@@ -77,64 +89,18 @@ func handlerTrack(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if res {
-			ID := rand.Intn(1000)
 
-			track, err := igc.ParseLocation(URL.URL)
-			if err != nil {
-				fmt.Fprintln(w, "Error made: ", err)
+			// Fetching a slow server in the request would keep the client waiting, it can be done in the background
+			split := wantsSplit(r)
+			if wantsAsync(r) {
+				respondQueuedJob(w, URL.URL, func(ctx context.Context) (tracks, bool, error) {
+					return registerTrackURL(ctx, URL.URL, split)
+				})
 				return
 			}
 
-			track.UniqueID = strconv.Itoa(ID)
-
-			trackFile := tracks{}
-
-			timestamp := time.Now().Second()
-			timestamp = timestamp * 1000
-
-			client := mongoConnect()
-
-			collection := client.Database("igcfiles").Collection("tracks")
-
-			// Checking for duplicates so that the user doesn't add into the database igc files with the same URL
-			duplicate := urlInMongo(URL.URL, collection)
-
-			if !duplicate {
-
-				trackFile = tracks{
-					track.UniqueID,
-					track.Pilot,
-					track.GliderType,
-					track.GliderID,
-					trackLength(track),
-					track.Date.String(),
-					URL.URL, time.Now()}
-
-				res, err := collection.InsertOne(context.Background(), trackFile)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				id := res.InsertedID
-
-				if id == nil {
-					http.Error(w, "", 300)
-				}
-
-				// Encoding the ID of the track that was just added to DB
-				fmt.Fprint(w, "{\n\"id\":\""+track.UniqueID+"\"\n}")
-
-				triggerWhenTrackIsAdded()
-
-			} else {
-
-				trackInDB := getTrack(client, URL.URL)
-				// If there is another file in igcFilesDB with that URL return and tell the user that that IGC FILE is already in the database
-				http.Error(w, "409 Conflict - The Igc File you entered is already in our database!", http.StatusConflict)
-				fmt.Fprintln(w, "\nThe file you entered has the following ID: ", trackInDB.UniqueID)
-				return
-
-			}
+			track, duplicate, err := registerTrackURL(r.Context(), URL.URL, split)
+			respondRegisteredTrack(w, track, duplicate, err)
 
 		}
 	default:
@@ -143,6 +109,7 @@ func handlerTrack(w http.ResponseWriter, r *http.Request) {
 	}
 
 }
+
 func handlerID(w http.ResponseWriter, r *http.Request) {
 	//Handling /igcinfo/api/igc/<id>
 	if r.Method != "GET" {
@@ -159,37 +126,26 @@ func handlerID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := mongoConnect()
-
-	collection := client.Database("igcfiles").Collection("tracks")
-
-	cursor, err := collection.Find(context.Background(), nil, nil)
+	track, found, err := tracksDB.TrackByID(r.Context(), idURL["id"])
 	if err != nil {
-		log.Fatal(err)
+		http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+		return
 	}
 
-	// 'Close' the cursor
-	defer cursor.Close(context.Background())
-
-	track := tracks{}
-	//URL := &_url{}
-
-	for cursor.Next(context.Background()) {
-		err = cursor.Decode(&track)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if track.UniqueID == idURL["id"] {
-			fmt.Fprint(w, "{\n\"H_date\":\""+track.Hdate+"\",\n\"pilot\":\""+track.Pilot+"\",\n\"glider\":\""+track.Glider+"\",\n\"glider_id\":\""+track.GliderID+"\",\n\"length\":\""+FloatToString(track.TrackLength)+"\",\n\"track_src_url\":\""+track.URL+"\"\n}")
-
-		} else {
-			//Handling if user type different id from ids stored
-			http.Error(w, "404 - The trackInfo with that id doesn't exists in our database ", http.StatusNotFound)
-
-		}
+	if !found {
+		//Handling if user type different id from ids stored
+		http.Error(w, "404 - The trackInfo with that id doesn't exists in our database ", http.StatusNotFound)
+		return
+	}
 
+	fmt.Fprint(w, "{\n\"H_date\":"+jsonString(track.Hdate)+",\n\"pilot\":"+jsonString(track.Pilot)+",\n\"glider\":"+jsonString(track.Glider)+",\n\"glider_id\":"+jsonString(track.GliderID)+",\n\"length\":\""+FloatToString(track.TrackLength)+"\",\n\"track_src_url\":"+jsonString(track.URL)+",\n\"takeoff_time\":\""+formatFlightTime(track.TakeoffTime)+"\",\n\"landing_time\":\""+formatFlightTime(track.LandingTime)+"\",\n\"duration\":\""+formatSeconds(track.Duration)+"\",\n\"airborne_time\":\""+formatSeconds(track.AirborneTime)+"\",\n\"validation_status\":\""+track.ValidationStatus+"\",\n\"igc_approved\":\""+strconv.FormatBool(track.IGCApproved)+"\"")
+	if len(track.Flights) > 0 {
+		fmt.Fprint(w, ",\n\"flight_number\":\""+strconv.Itoa(track.flightNumber())+"\",\n\"source_flights\":[\""+strings.Join(track.Flights, "\",\"")+"\"]")
 	}
+	for _, field := range track.apiFields() {
+		fmt.Fprint(w, ",\n\""+field.Name+"\":"+jsonString(field.Value))
+	}
+	fmt.Fprint(w, "\n}")
 
 }
 
@@ -217,11 +173,17 @@ func handlerField(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "400 - Bad Request, wrong parameters", http.StatusBadRequest)
 		return
 	}
-	client := mongoConnect()
 
-	trackDB := tracks{}
+	trackDB, found, err := tracksDB.TrackByID(r.Context(), urlFields["id"])
+	if err != nil {
+		http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "File not found!", 404)
+		return
+	}
 
-	trackDB = getTrack1(client, urlFields["id"], w)
 	// Taking the field variable from the URL path and converting it to lower case to skip some potential errors
 	field := urlFields["field"]
 
@@ -238,7 +200,29 @@ func handlerField(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprint(w, trackDB.TrackLength)
 	case "track_src_url":
 		fmt.Fprint(w, trackDB.URL)
+	case "takeoff_time":
+		fmt.Fprint(w, formatFlightTime(trackDB.TakeoffTime))
+	case "landing_time":
+		fmt.Fprint(w, formatFlightTime(trackDB.LandingTime))
+	case "duration":
+		fmt.Fprint(w, formatSeconds(trackDB.Duration))
+	case "airborne_time":
+		fmt.Fprint(w, formatSeconds(trackDB.AirborneTime))
+	case "validation_status":
+		fmt.Fprint(w, trackDB.ValidationStatus)
+	case "igc_approved":
+		fmt.Fprint(w, trackDB.IGCApproved)
+	case "flight_number":
+		fmt.Fprint(w, trackDB.flightNumber())
+	case "source_flights":
+		fmt.Fprint(w, strings.Join(trackDB.Flights, ","))
 	default:
+		for _, value := range trackDB.apiFields() {
+			if value.Name == field {
+				fmt.Fprint(w, value.Value)
+				return
+			}
+		}
 		http.Error(w, "", 404)
 	}
 
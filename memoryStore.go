@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryStore keeps tracks and webhooks in memory
+// Everything is lost when the service stops, it is meant for development and tests
+type memoryStore struct {
+	mu        sync.RWMutex
+	tracks    []tracks
+	webhooks  []Webhook
+	sequences map[string]int64
+	trackData map[string]trackData
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{sequences: map[string]int64{}, trackData: map[string]trackData{}}
+}
+
+// NextID returns the next value of the named sequence
+func (s *memoryStore) NextID(ctx context.Context, sequence string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sequences[sequence]++
+	return s.sequences[sequence], nil
+}
+
+// InsertTrack stores a new track
+func (s *memoryStore) InsertTrack(ctx context.Context, track tracks) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, val := range s.tracks {
+		if val.UniqueID == track.UniqueID {
+			return errDuplicateID
+		}
+	}
+	s.tracks = append(s.tracks, track)
+	return nil
+}
+
+// UpdateTrack replaces the track with the same ID
+func (s *memoryStore) UpdateTrack(ctx context.Context, track tracks) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, val := range s.tracks {
+		if val.UniqueID == track.UniqueID {
+			s.tracks[key] = track
+		}
+	}
+	return nil
+}
+
+// DeleteTrack removes the track with the given ID and its data
+func (s *memoryStore) DeleteTrack(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.trackData, id)
+	for key, val := range s.tracks {
+		if val.UniqueID == id {
+			s.tracks = append(s.tracks[:key], s.tracks[key+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// TrackByID returns the track with the given ID
+func (s *memoryStore) TrackByID(ctx context.Context, id string) (tracks, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, val := range s.tracks {
+		if val.UniqueID == id {
+			return val, true, nil
+		}
+	}
+	return tracks{}, false, nil
+}
+
+// TrackByURL returns the track registered with the given URL
+func (s *memoryStore) TrackByURL(ctx context.Context, url string) (tracks, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Uploaded tracks have no URL
+	if url == "" {
+		return tracks{}, false, nil
+	}
+
+	for _, val := range s.tracks {
+		if val.URL == url {
+			return val, true, nil
+		}
+	}
+	return tracks{}, false, nil
+}
+
+// TrackByHash returns the track whose IGC file has the given content hash
+func (s *memoryStore) TrackByHash(ctx context.Context, hash string) (tracks, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if hash == "" {
+		return tracks{}, false, nil
+	}
+
+	for _, val := range s.tracks {
+		if val.ContentHash == hash {
+			return val, true, nil
+		}
+	}
+	return tracks{}, false, nil
+}
+
+// AllTracks returns a copy of every track
+func (s *memoryStore) AllTracks(ctx context.Context) ([]tracks, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resTracks := make([]tracks, len(s.tracks))
+	copy(resTracks, s.tracks)
+	return resTracks, nil
+}
+
+// TrackIDs returns the IDs of every track
+func (s *memoryStore) TrackIDs(ctx context.Context) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.tracks))
+	for _, val := range s.tracks {
+		ids = append(ids, val.UniqueID)
+	}
+	return ids, nil
+}
+
+// CountTracks returns the number of stored tracks
+func (s *memoryStore) CountTracks(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return int64(len(s.tracks)), nil
+}
+
+// DeleteAllTracks removes every track
+func (s *memoryStore) DeleteAllTracks(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := int64(len(s.tracks))
+	s.tracks = nil
+	s.trackData = map[string]trackData{}
+	return count, nil
+}
+
+// SaveTrackData stores the IGC file and the fixes of a track
+func (s *memoryStore) SaveTrackData(ctx context.Context, data trackData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trackData[data.UniqueID] = data
+	return nil
+}
+
+// TrackData returns the IGC file and the fixes of the track with the given ID
+func (s *memoryStore) TrackData(ctx context.Context, id string) (trackData, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, found := s.trackData[id]
+	return data, found, nil
+}
+
+// InsertWebhook stores a new webhook
+func (s *memoryStore) InsertWebhook(ctx context.Context, webhook Webhook) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, val := range s.webhooks {
+		if val.WebhookID == webhook.WebhookID {
+			return errDuplicateID
+		}
+	}
+	s.webhooks = append(s.webhooks, webhook)
+	return nil
+}
+
+// WebhookByID returns the webhook with the given ID
+func (s *memoryStore) WebhookByID(ctx context.Context, id string) (Webhook, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, val := range s.webhooks {
+		if val.WebhookID == id {
+			return val, true, nil
+		}
+	}
+	return Webhook{}, false, nil
+}
+
+// WebhookByURL returns the webhook registered for the given URL
+func (s *memoryStore) WebhookByURL(ctx context.Context, url string) (Webhook, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, val := range s.webhooks {
+		if val.WebhookURL == url {
+			return val, true, nil
+		}
+	}
+	return Webhook{}, false, nil
+}
+
+// UpdateWebhookTrigger changes the minTriggerValue of the webhook with the given URL
+func (s *memoryStore) UpdateWebhookTrigger(ctx context.Context, url string, minTriggerValue int32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, val := range s.webhooks {
+		if val.WebhookURL == url {
+			s.webhooks[key].MinTriggerValue = minTriggerValue
+		}
+	}
+	return nil
+}
+
+// DeleteWebhook removes the webhook with the given ID
+func (s *memoryStore) DeleteWebhook(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, val := range s.webhooks {
+		if val.WebhookID == id {
+			s.webhooks = append(s.webhooks[:key], s.webhooks[key+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// AllWebhooks returns a copy of every webhook
+func (s *memoryStore) AllWebhooks(ctx context.Context) ([]Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	resWebhooks := make([]Webhook, len(s.webhooks))
+	copy(resWebhooks, s.webhooks)
+	return resWebhooks, nil
+}
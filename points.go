@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// *** SIMPLIFIED POINTS *** //
+
+// trackPoints is the response of GET /api/track/<id>/points, one array per value so the fixes stay small in JSON
+type trackPoints struct {
+	Count int         `json:"count"`
+	Time  []time.Time `json:"time"`
+	Lat   []float64   `json:"lat"`
+	Lon   []float64   `json:"lon"`
+	Alt   []int64     `json:"alt"` // m, from the GPS or the pressure sensor when the GPS had none
+}
+
+// pointsQuery holds the options of GET /api/track/<id>/points, the zero values leave the fixes as they are
+type pointsQuery struct {
+	From, To  time.Time     // Only the fixes between the two times, both included
+	Interval  time.Duration // At most one fix per interval
+	Tolerance float64       // m, the Douglas-Peucker tolerance
+}
+
+// Returns the fixes recorded between from and to, a zero time leaves that side open
+func fixesBetween(fixes []fix, from, to time.Time) []fix {
+	window := []fix{}
+	for _, f := range fixes {
+		if (from.IsZero() || !f.Time.Before(from)) && (to.IsZero() || !f.Time.After(to)) {
+			window = append(window, f)
+		}
+	}
+	return window
+}
+
+// Keeps the first fix of every interval, starting at the first fix
+// The fixes are not interpolated, so the points returned were all recorded
+func resampleFixes(fixes []fix, interval time.Duration) []fix {
+	if len(fixes) == 0 || interval <= 0 {
+		return fixes
+	}
+	resampled := []fix{fixes[0]}
+	next := fixes[0].Time.Add(interval)
+	for _, f := range fixes[1:] {
+		if f.Time.Before(next) {
+			continue
+		}
+		resampled = append(resampled, f)
+		// A gap in the recording skips the empty intervals
+		for !f.Time.Before(next) {
+			next = next.Add(interval)
+		}
+	}
+	return resampled
+}
+
+// Returns the distance in meters from p to the segment between a and b,
+// on a plane tangent to the earth at a, which is precise enough over the length of a segment
+func segmentDistance(p, a, b fix) float64 {
+	scale := math.Cos(a.Lat * math.Pi / 180)
+	toMeters := func(f fix) (float64, float64) {
+		return (f.Lon - a.Lon) * scale * math.Pi / 180 * earthRadius * 1000, (f.Lat - a.Lat) * math.Pi / 180 * earthRadius * 1000
+	}
+	px, py := toMeters(p)
+	bx, by := toMeters(b)
+
+	length := bx*bx + by*by
+	if length == 0 {
+		return math.Hypot(px, py)
+	}
+	t := math.Max(0, math.Min(1, (px*bx+py*by)/length))
+	return math.Hypot(px-t*bx, py-t*by)
+}
+
+// Simplifies the line of the fixes with the Douglas-Peucker algorithm: every fix left out is within tolerance meters
+// of the simplified line. The first and the last fixes are always kept
+func simplifyFixes(fixes []fix, tolerance float64) []fix {
+	if len(fixes) < 3 || tolerance <= 0 {
+		return fixes
+	}
+
+	keep := make([]bool, len(fixes))
+	keep[0], keep[len(fixes)-1] = true, true
+
+	// The segments still to simplify, without recursion as a flight has tens of thousands of fixes
+	stack := [][2]int{{0, len(fixes) - 1}}
+	for len(stack) > 0 {
+		segment := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		farthest, distance := -1, tolerance
+		for i := segment[0] + 1; i < segment[1]; i++ {
+			if d := segmentDistance(fixes[i], fixes[segment[0]], fixes[segment[1]]); d > distance {
+				farthest, distance = i, d
+			}
+		}
+		if farthest >= 0 {
+			keep[farthest] = true
+			stack = append(stack, [2]int{segment[0], farthest}, [2]int{farthest, segment[1]})
+		}
+	}
+
+	simplified := []fix{}
+	for i, f := range fixes {
+		if keep[i] {
+			simplified = append(simplified, f)
+		}
+	}
+	return simplified
+}
+
+// Applies the options to the fixes: the time window, then the resampling, then the simplification
+func (q pointsQuery) apply(fixes []fix) []fix {
+	fixes = fixesBetween(fixes, q.From, q.To)
+	fixes = resampleFixes(fixes, q.Interval)
+	return simplifyFixes(fixes, q.Tolerance)
+}
+
+func newTrackPoints(fixes []fix) trackPoints {
+	points := trackPoints{
+		Count: len(fixes),
+		Time:  make([]time.Time, len(fixes)),
+		Lat:   make([]float64, len(fixes)),
+		Lon:   make([]float64, len(fixes)),
+		Alt:   make([]int64, len(fixes)),
+	}
+	for i, f := range fixes {
+		points.Time[i], points.Lat[i], points.Lon[i], points.Alt[i] = f.Time, f.Lat, f.Lon, f.altitude()
+	}
+	return points
+}
+
+// Reads the options of GET /api/track/<id>/points, the message is the reason of the 400 when they are wrong
+func parsePointsQuery(r *http.Request) (pointsQuery, string) {
+	query := pointsQuery{}
+	values := r.URL.Query()
+
+	if param := values.Get("tolerance"); param != "" {
+		tolerance, err := strconv.ParseFloat(param, 64)
+		if err != nil || tolerance < 0 {
+			return query, "the tolerance must be a number of meters"
+		}
+		query.Tolerance = tolerance
+	}
+	if param := values.Get("interval"); param != "" {
+		seconds, err := strconv.Atoi(param)
+		if err != nil || seconds < 1 {
+			return query, "the interval must be a positive number of seconds"
+		}
+		query.Interval = time.Duration(seconds) * time.Second
+	}
+	for name, value := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if param := values.Get(name); param != "" {
+			t, err := time.Parse(time.RFC3339, param)
+			if err != nil {
+				return query, "the " + name + " time must be in the RFC 3339 format, eg. 2018-07-14T10:00:00Z"
+			}
+			*value = t
+		}
+	}
+	if !query.From.IsZero() && !query.To.IsZero() && query.To.Before(query.From) {
+		return query, "the from time must be before the to time"
+	}
+	return query, ""
+}
+
+// Handles path: GET /api/track/<id>/points
+// Returns the fixes of the track as arrays of times, latitudes, longitudes and altitudes, lighter for the maps with:
+// ?from=<RFC 3339>&to=<RFC 3339> for a time window, ?interval=<seconds> for at most one fix per interval
+// and ?tolerance=<meters> for the Douglas-Peucker simplification. The stored fixes are not changed
+func handlerPoints(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	query, problem := parsePointsQuery(r)
+	if problem != "" {
+		http.Error(w, "400 - Bad Request, "+problem, http.StatusBadRequest)
+		return
+	}
+
+	data, ok := requestedTrackData(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newTrackPoints(query.apply(data.Fixes)))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_simplifyFixes(t *testing.T) {
+	straight := moveNorth(nil, 10*time.Minute, 30, 0)
+	if simplified := simplifyFixes(straight, 1); len(simplified) != 2 {
+		t.Errorf("Expected a straight line to keep its two ends, got %d fixes", len(simplified))
+	}
+
+	// North 1 km, then east 1 km: the corner is kept, and nothing else
+	corner := routeThrough([2]float64{0, 0}, [2]float64{1, 0}, [2]float64{1, 1})
+	simplified := simplifyFixes(corner, 10)
+	if len(simplified) != 3 || !simplified[1].Time.Equal(corner[100].Time) {
+		t.Errorf("Expected the two ends and the corner, got %d fixes", len(simplified))
+	}
+
+	for _, f := range corner {
+		if d := segmentDistance(f, simplified[0], simplified[1]); d > 10 && segmentDistance(f, simplified[1], simplified[2]) > 10 {
+			t.Fatalf("Expected every fix within 10 m of the simplified line, %s is %f m away", f.Time, d)
+		}
+	}
+}
+
+func Test_resampleFixes(t *testing.T) {
+	fixes := moveNorth(nil, 60*time.Second, 30, 0)
+	second := moveNorth(nil, 10*time.Second, 30, 0)
+	for i := range second {
+		second[i].Time = second[i].Time.Add(time.Hour)
+	}
+	fixes = append(fixes, second...)
+
+	resampled := resampleFixes(fixes, 10*time.Second)
+	// 0, 10, ... 60 s, then the fixes at 1 h and 1 h 10 s
+	if len(resampled) != 9 || !resampled[7].Time.Equal(flightStart.Add(time.Hour)) {
+		t.Errorf("Expected 9 fixes, a new interval starting after the gap, got %d", len(resampled))
+	}
+}
+
+func Test_handlerPoints(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+	stored, _, _ := tracksDB.TrackData(context.Background(), "1")
+
+	router := newRouter()
+	get := func(query string) (int, trackPoints) {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/points"+query, nil))
+		points := trackPoints{}
+		json.NewDecoder(rec.Body).Decode(&points)
+		return rec.Code, points
+	}
+
+	status, all := get("")
+	if status != http.StatusOK || all.Count != len(stored.Fixes) || len(all.Lat) != all.Count || len(all.Time) != all.Count {
+		t.Fatalf("Expected every fix, got %d with %d of %d", status, all.Count, len(stored.Fixes))
+	}
+
+	// The sample is mostly circling in thermals, which a simplified line can't cut through
+	_, simplified := get("?tolerance=20")
+	if simplified.Count < 2 || simplified.Count >= all.Count/2 {
+		t.Errorf("Expected the simplification to drop most fixes, got %d of %d", simplified.Count, all.Count)
+	}
+
+	_, window := get("?from=2017-08-09T12:20:00Z&to=2017-08-09T12:30:00Z&interval=60")
+	if window.Count != 10 || !window.Time[0].Equal(time.Date(2017, 8, 9, 12, 20, 3, 0, time.UTC)) {
+		t.Errorf("Expected a fix a minute for 10 minutes, got %d starting at %v", window.Count, window.Time)
+	}
+
+	for _, query := range []string{"?tolerance=-1", "?interval=0", "?from=yesterday", "?from=2017-08-09T13:00:00Z&to=2017-08-09T12:00:00Z"} {
+		if status, _ := get(query); status != http.StatusBadRequest {
+			t.Errorf("%s: expected StatusBadRequest %d, got %d", query, http.StatusBadRequest, status)
+		}
+	}
+
+	if data, _, _ := tracksDB.TrackData(context.Background(), "1"); len(data.Fixes) != len(stored.Fixes) {
+		t.Errorf("Expected the stored fixes to stay the same")
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// *** CROSS-COUNTRY SCORING *** //
+
+// Types of the scored routes
+const (
+	routeFreeDistance = "free_distance"
+	routeFlatTriangle = "flat_triangle"
+	routeFAITriangle  = "fai_triangle"
+)
+
+const (
+	// The turnpoints are searched among this many fixes spread over the flight,
+	// then moved to the best fix around them
+	maxFreeDistancePoints = 500
+	maxTrianglePoints     = 150
+	// Every leg of a FAI triangle is at least this part of its perimeter
+	faiMinLegRatio = 0.28
+	// At most this many rounds of moving the turnpoints to a better fix
+	maxRefineRounds = 50
+)
+
+// routePoint is a start, turnpoint or finish of a scored route
+type routePoint struct {
+	Time time.Time `json:"time"`
+	Lat  float64   `json:"lat"`
+	Lon  float64   `json:"lon"`
+}
+
+// scoredRoute is the best route of a type found in the flight
+type scoredRoute struct {
+	Type            string       `json:"type"`
+	Start           routePoint   `json:"start"`
+	Turnpoints      []routePoint `json:"turnpoints"`
+	Finish          routePoint   `json:"finish"`
+	Distance        float64      `json:"distance"`                   // Km, without the closing distance for the triangles
+	ClosingDistance float64      `json:"closing_distance,omitempty"` // Km between the start and the finish of a triangle
+	Multiplier      float64      `json:"multiplier"`
+	Score           float64      `json:"score"` // Points, the distance times the multiplier
+}
+
+// trackScore is the cross-country score of a track, stored with it
+type trackScore struct {
+	Best   string        `json:"best"`   // Type of the route with the most points, empty when there was no flight
+	Score  float64       `json:"score"`  // Points of the best route
+	Routes []scoredRoute `json:"routes"` // The free distance, and the triangles when the flight closed one
+}
+
+// Returns the indexes of at most n fixes spread evenly over count fixes, with the first and the last one
+func sampleIndexes(count, n int) []int {
+	if count <= n {
+		indexes := make([]int, count)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes
+	}
+
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i * (count - 1) / (n - 1)
+	}
+	return indexes
+}
+
+// Returns the distances between every two of the sampled fixes
+func distanceMatrix(fixes []fix, samples []int) [][]float64 {
+	distances := make([][]float64, len(samples))
+	for i := range samples {
+		distances[i] = make([]float64, len(samples))
+		for j := 0; j < i; j++ {
+			distances[i][j] = fixDistance(fixes[samples[i]], fixes[samples[j]])
+			distances[j][i] = distances[i][j]
+		}
+	}
+	return distances
+}
+
+// Returns the length in km of the route through the fixes at the indexes
+func routeLength(fixes []fix, route []int) float64 {
+	length := 0.0
+	for i := 1; i < len(route); i++ {
+		length += fixDistance(fixes[route[i-1]], fixes[route[i]])
+	}
+	return length
+}
+
+// Moves every point of the route to the fix within radius that makes the objective bigger, until none does
+// The points stay in the order of the fixes
+func refineRoute(count int, route []int, radius int, objective func(route []int) float64) []int {
+	best := objective(route)
+	for round := 0; round < maxRefineRounds; round++ {
+		improved := false
+		for k := range route {
+			lo, hi := route[k]-radius, route[k]+radius
+			if k > 0 && lo < route[k-1] {
+				lo = route[k-1]
+			}
+			if k < len(route)-1 && hi > route[k+1] {
+				hi = route[k+1]
+			}
+			if lo < 0 {
+				lo = 0
+			}
+			if hi > count-1 {
+				hi = count - 1
+			}
+
+			chosen := route[k]
+			for i := lo; i <= hi; i++ {
+				route[k] = i
+				if value := objective(route); value > best {
+					best, chosen, improved = value, i, true
+				}
+			}
+			route[k] = chosen
+		}
+		if !improved {
+			break
+		}
+	}
+	return route
+}
+
+// Finds the start, the three turnpoints and the finish giving the longest distance
+// The points can be the same fix, so routes with fewer turnpoints are found too
+func bestFreeDistance(fixes []fix) []int {
+	samples := sampleIndexes(len(fixes), maxFreeDistancePoints)
+	distances := distanceMatrix(fixes, samples)
+
+	// longest[k][j] is the longest route of k legs finishing at the sample j, from[k][j] the previous point
+	const legs = 4
+	longest := make([][]float64, legs+1)
+	from := make([][]int, legs+1)
+	for k := range longest {
+		longest[k] = make([]float64, len(samples))
+		from[k] = make([]int, len(samples))
+	}
+	for k := 1; k <= legs; k++ {
+		for j := range samples {
+			for i := 0; i <= j; i++ {
+				if length := longest[k-1][i] + distances[i][j]; length >= longest[k][j] {
+					longest[k][j], from[k][j] = length, i
+				}
+			}
+		}
+	}
+
+	finish := 0
+	for j := range samples {
+		if longest[legs][j] > longest[legs][finish] {
+			finish = j
+		}
+	}
+	route := make([]int, legs+1)
+	route[legs] = finish
+	for k := legs; k > 0; k-- {
+		route[k-1] = from[k][route[k]]
+	}
+	for k := range route {
+		route[k] = samples[route[k]]
+	}
+
+	return refineRoute(len(fixes), route, len(fixes)/len(samples)+1, func(route []int) float64 {
+		return routeLength(fixes, route)
+	})
+}
+
+// Returns the distance of the triangle start, a, b, c, finish: its perimeter less the closing distance,
+// or -1 when the start and the finish are too far apart or a FAI triangle has a short leg
+func triangleDistance(perimeter, shortestLeg, closing, closingRatio float64, fai bool) float64 {
+	if perimeter <= 0 || closing > closingRatio*perimeter || (fai && shortestLeg < faiMinLegRatio*perimeter) {
+		return -1
+	}
+	return perimeter - closing
+}
+
+// Finds the start, the three turnpoints and the finish of the best triangle, or nil when the flight closed none
+func bestTriangle(fixes []fix, closingRatio float64, fai bool) []int {
+	samples := sampleIndexes(len(fixes), maxTrianglePoints)
+	distances := distanceMatrix(fixes, samples)
+	n := len(samples)
+
+	// closing[a][c] is the shortest distance from a start before a to a finish after c, ends[a][c] the start and finish
+	closing := make([][]float64, n)
+	ends := make([][][2]int, n)
+	for a := 0; a < n; a++ {
+		closing[a] = make([]float64, n)
+		ends[a] = make([][2]int, n)
+		for c := n - 1; c >= a; c-- {
+			closing[a][c], ends[a][c] = distances[a][c], [2]int{a, c}
+			if a > 0 && closing[a-1][c] < closing[a][c] {
+				closing[a][c], ends[a][c] = closing[a-1][c], ends[a-1][c]
+			}
+			if c < n-1 && closing[a][c+1] < closing[a][c] {
+				closing[a][c], ends[a][c] = closing[a][c+1], ends[a][c+1]
+			}
+		}
+	}
+
+	var route []int
+	best := 0.0
+	for a := 0; a < n; a++ {
+		for b := a + 1; b < n; b++ {
+			for c := b + 1; c < n; c++ {
+				ab, bc, ca := distances[a][b], distances[b][c], distances[c][a]
+				distance := triangleDistance(ab+bc+ca, math.Min(ab, math.Min(bc, ca)), closing[a][c], closingRatio, fai)
+				if distance > best {
+					best = distance
+					route = []int{ends[a][c][0], a, b, c, ends[a][c][1]}
+				}
+			}
+		}
+	}
+	if route == nil {
+		return nil
+	}
+	for k := range route {
+		route[k] = samples[route[k]]
+	}
+
+	return refineRoute(len(fixes), route, len(fixes)/len(samples)+1, func(route []int) float64 {
+		ab := fixDistance(fixes[route[1]], fixes[route[2]])
+		bc := fixDistance(fixes[route[2]], fixes[route[3]])
+		ca := fixDistance(fixes[route[3]], fixes[route[1]])
+		closing := fixDistance(fixes[route[0]], fixes[route[4]])
+		return triangleDistance(ab+bc+ca, math.Min(ab, math.Min(bc, ca)), closing, closingRatio, fai)
+	})
+}
+
+func newRoutePoint(f fix) routePoint {
+	return routePoint{Time: f.Time, Lat: f.Lat, Lon: f.Lon}
+}
+
+// Builds the scored route through the fixes at the indexes: start, turnpoints and finish
+func newScoredRoute(fixes []fix, routeType string, route []int, multiplier float64) scoredRoute {
+	scored := scoredRoute{
+		Type:       routeType,
+		Start:      newRoutePoint(fixes[route[0]]),
+		Finish:     newRoutePoint(fixes[route[len(route)-1]]),
+		Turnpoints: []routePoint{},
+		Multiplier: multiplier,
+	}
+
+	if routeType == routeFreeDistance {
+		// The points on the same fix are a single turnpoint
+		previous := route[0]
+		for _, i := range route[1 : len(route)-1] {
+			if i != previous && i != route[len(route)-1] {
+				scored.Turnpoints = append(scored.Turnpoints, newRoutePoint(fixes[i]))
+			}
+			previous = i
+		}
+		scored.Distance = routeLength(fixes, route)
+	} else {
+		for _, i := range route[1:4] {
+			scored.Turnpoints = append(scored.Turnpoints, newRoutePoint(fixes[i]))
+		}
+		scored.ClosingDistance = math.Round(fixDistance(fixes[route[0]], fixes[route[4]])*100) / 100
+		scored.Distance = routeLength(fixes, []int{route[1], route[2], route[3], route[1]}) - fixDistance(fixes[route[0]], fixes[route[4]])
+	}
+
+	scored.Score = math.Round(scored.Distance*multiplier*100) / 100
+	scored.Distance = math.Round(scored.Distance*100) / 100
+	return scored
+}
+
+// Scores the fixes of a flight like the cross-country competitions: the best free distance
+// over up to three turnpoints, flat triangle and FAI triangle, each with its multiplier
+func scoreFlight(fixes []fix, cfg ScoringConfig) trackScore {
+	score := trackScore{Routes: []scoredRoute{}}
+	if len(fixes) < 2 {
+		return score
+	}
+
+	score.Routes = append(score.Routes, newScoredRoute(fixes, routeFreeDistance, bestFreeDistance(fixes), cfg.FreeDistance))
+	if route := bestTriangle(fixes, cfg.ClosingRatio, false); route != nil {
+		score.Routes = append(score.Routes, newScoredRoute(fixes, routeFlatTriangle, route, cfg.FlatTriangle))
+	}
+	if route := bestTriangle(fixes, cfg.ClosingRatio, true); route != nil {
+		score.Routes = append(score.Routes, newScoredRoute(fixes, routeFAITriangle, route, cfg.FAITriangle))
+	}
+
+	for _, route := range score.Routes {
+		if route.Score > score.Score {
+			score.Best, score.Score = route.Type, route.Score
+		}
+	}
+	return score
+}
+
+// Handles path: GET /api/track/<id>/score
+// Returns the cross-country score stored with the track, with the turnpoints of every route
+func handlerScore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	if !regexp.MustCompile(`^[0-9]+$`).MatchString(id) {
+		http.Error(w, "400 - Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	track, found, err := tracksDB.TrackByID(r.Context(), id)
+	if err != nil {
+		http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "404 - The trackInfo with that id doesn't exists in our database ", http.StatusNotFound)
+		return
+	}
+
+	if track.Score.Routes == nil {
+		track.Score.Routes = []scoredRoute{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(track.Score)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Returns the point at the given km north and east of lat, lon
+func offsetKm(lat, lon, north, east float64) (float64, float64) {
+	kmPerDegree := earthRadius * math.Pi / 180
+	return lat + north/kmPerDegree, lon + east/(kmPerDegree*math.Cos(lat*math.Pi/180))
+}
+
+// Returns fixes flying straight through the waypoints (km north and east of 46, 7), one every 10 seconds
+// and 100 on every leg
+func routeThrough(waypoints ...[2]float64) []fix {
+	var fixes []fix
+	t := flightStart
+	for i := 1; i < len(waypoints); i++ {
+		from, to := waypoints[i-1], waypoints[i]
+		for step := 0; step < 100; step++ {
+			ratio := float64(step) / 100
+			lat, lon := offsetKm(46, 7, from[0]+(to[0]-from[0])*ratio, from[1]+(to[1]-from[1])*ratio)
+			fixes = append(fixes, fix{Time: t, Lat: lat, Lon: lon, GPSAltitude: 1500})
+			t = t.Add(10 * time.Second)
+		}
+	}
+	last := waypoints[len(waypoints)-1]
+	lat, lon := offsetKm(46, 7, last[0], last[1])
+	return append(fixes, fix{Time: t, Lat: lat, Lon: lon, GPSAltitude: 1500})
+}
+
+func routeByType(score trackScore, routeType string) (scoredRoute, bool) {
+	for _, route := range score.Routes {
+		if route.Type == routeType {
+			return route, true
+		}
+	}
+	return scoredRoute{}, false
+}
+
+func Test_scoreFlight_Straight(t *testing.T) {
+	score := scoreFlight(routeThrough([2]float64{0, 0}, [2]float64{30, 0}), defaultConfig().Scoring)
+
+	free, found := routeByType(score, routeFreeDistance)
+	if !found || math.Abs(free.Distance-30) > 0.05 || free.Score != free.Distance {
+		t.Errorf("Expected a free distance of 30 km, got %+v", free)
+	}
+	if len(score.Routes) != 1 || score.Best != routeFreeDistance {
+		t.Errorf("Expected no triangle on a straight line, got %+v", score)
+	}
+}
+
+func Test_scoreFlight_OutAndReturn(t *testing.T) {
+	score := scoreFlight(routeThrough([2]float64{0, 0}, [2]float64{20, 0}, [2]float64{0, 0}), defaultConfig().Scoring)
+
+	free, _ := routeByType(score, routeFreeDistance)
+	if math.Abs(free.Distance-40) > 0.05 || len(free.Turnpoints) == 0 {
+		t.Errorf("Expected a free distance of 40 km through the turnpoint, got %+v", free)
+	}
+	// A flat triangle, but no leg can be long enough for a FAI one
+	flat, found := routeByType(score, routeFlatTriangle)
+	if !found || math.Abs(flat.Distance-40) > 0.05 || flat.ClosingDistance > 0.05 {
+		t.Errorf("Expected a closed flat triangle of 40 km, got %+v", flat)
+	}
+	if _, found := routeByType(score, routeFAITriangle); found {
+		t.Errorf("Expected no FAI triangle, got %+v", score)
+	}
+	if score.Best != routeFlatTriangle || math.Abs(score.Score-48) > 0.1 {
+		t.Errorf("Expected the flat triangle to score 48 points, got %s %f", score.Best, score.Score)
+	}
+}
+
+func Test_scoreFlight_FAITriangle(t *testing.T) {
+	// An equilateral triangle of 10 km legs, the finish 1 km away from the start
+	score := scoreFlight(routeThrough([2]float64{0, 0}, [2]float64{10, 0}, [2]float64{5, 8.66}, [2]float64{0, 1}), defaultConfig().Scoring)
+
+	fai, found := routeByType(score, routeFAITriangle)
+	if !found || len(fai.Turnpoints) != 3 {
+		t.Fatalf("Expected a FAI triangle, got %+v", score)
+	}
+	if math.Abs(fai.Distance+fai.ClosingDistance-30) > 0.5 || fai.ClosingDistance > 1.01 {
+		t.Errorf("Expected a perimeter of about 30 km closed within 1 km, got %f and %f", fai.Distance+fai.ClosingDistance, fai.ClosingDistance)
+	}
+	if score.Best != routeFAITriangle || score.Score != fai.Score || math.Abs(fai.Score-fai.Distance*1.4) > 0.01 {
+		t.Errorf("Expected the FAI triangle to be the best route, got %+v", score)
+	}
+}
+
+func Test_scoreFlight_Multipliers(t *testing.T) {
+	cfg := defaultConfig().Scoring
+	cfg.FreeDistance = 2
+	score := scoreFlight(routeThrough([2]float64{0, 0}, [2]float64{20, 0}, [2]float64{0, 0}), cfg)
+	if score.Best != routeFreeDistance || math.Abs(score.Score-80) > 0.1 {
+		t.Errorf("Expected the free distance to score 80 points, got %s %f", score.Best, score.Score)
+	}
+
+	// With no closing allowed the start and the finish must be the same fix
+	cfg = defaultConfig().Scoring
+	cfg.ClosingRatio = 0
+	score = scoreFlight(routeThrough([2]float64{0, 0}, [2]float64{10, 0}, [2]float64{5, 8.66}, [2]float64{0, 1}), cfg)
+	if _, found := routeByType(score, routeFAITriangle); found {
+		t.Errorf("Expected no triangle without closing, got %+v", score)
+	}
+}
+
+func Test_scoreFlight_NoFlight(t *testing.T) {
+	score := scoreFlight(nil, defaultConfig().Scoring)
+	if score.Best != "" || score.Score != 0 || len(score.Routes) != 0 {
+		t.Errorf("Expected no score without a flight, got %+v", score)
+	}
+}
+
+func Test_handlerScore(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	router := newRouter()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/score", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	var score trackScore
+	if err := json.NewDecoder(rec.Body).Decode(&score); err != nil {
+		t.Fatalf("Error decoding the score, %s", err)
+	}
+	free, found := routeByType(score, routeFreeDistance)
+	if !found || free.Distance <= 0 || score.Score < free.Score {
+		t.Errorf("Expected a free distance and the best score, got %+v", score)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/2/score", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown track, got %d", rec.Code)
+	}
+}
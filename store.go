@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// *** STORAGE *** //
+
+// errDuplicateID is returned by a store when a track or webhook with the same ID is already stored
+var errDuplicateID = errors.New("a record with that ID already exists")
+
+// Names of the ID sequences
+const (
+	sequenceTracks   = "tracks"
+	sequenceWebhooks = "webhooks"
+)
+
+// IDAllocator hands out the IDs of new tracks and webhooks
+type IDAllocator interface {
+	// NextID returns the next value of the named sequence, starting at 1
+	// A value is never handed out twice, even if the records using it are deleted
+	NextID(ctx context.Context, sequence string) (int64, error)
+}
+
+// Returns the highest ID in ids that is a number, or 0
+// Used to start the sequences after the IDs stored before the sequences existed
+func maxNumericID(ids []string) int64 {
+	var max int64
+	for _, id := range ids {
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil && n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// TrackStore keeps the igc tracks registered through the API
+// The handlers only talk to this interface, so the backend can be swapped without touching them
+type TrackStore interface {
+	IDAllocator
+	// InsertTrack stores a new track
+	InsertTrack(ctx context.Context, track tracks) error
+	// UpdateTrack replaces the stored track that has the same ID
+	UpdateTrack(ctx context.Context, track tracks) error
+	// TrackByID returns the track with the given ID, and false if there is none
+	TrackByID(ctx context.Context, id string) (tracks, bool, error)
+	// TrackByURL returns the track registered with the given source URL, and false if there is none
+	TrackByURL(ctx context.Context, url string) (tracks, bool, error)
+	// TrackByHash returns the track whose IGC file has the given content hash, and false if there is none
+	TrackByHash(ctx context.Context, hash string) (tracks, bool, error)
+	// AllTracks returns every track, in the order they were added
+	AllTracks(ctx context.Context) ([]tracks, error)
+	// TrackIDs returns the IDs of every track, in the order they were added
+	TrackIDs(ctx context.Context) ([]string, error)
+	// CountTracks returns the number of stored tracks
+	CountTracks(ctx context.Context) (int64, error)
+	// DeleteTrack removes the track with the given ID and its data, nothing happens when there is none
+	DeleteTrack(ctx context.Context, id string) error
+	// DeleteAllTracks removes every track, with their data, and returns how many were removed
+	DeleteAllTracks(ctx context.Context) (int64, error)
+	// SaveTrackData stores the IGC file and the fixes of a track, replacing the ones stored before
+	SaveTrackData(ctx context.Context, data trackData) error
+	// TrackData returns the IGC file and the fixes of the track with the given ID, and false if there are none
+	TrackData(ctx context.Context, id string) (trackData, bool, error)
+}
+
+// WebhookStore keeps the webhooks registered for new track notifications
+type WebhookStore interface {
+	IDAllocator
+	// InsertWebhook stores a new webhook
+	InsertWebhook(ctx context.Context, webhook Webhook) error
+	// WebhookByID returns the webhook with the given ID, and false if there is none
+	WebhookByID(ctx context.Context, id string) (Webhook, bool, error)
+	// WebhookByURL returns the webhook registered for the given URL, and false if there is none
+	WebhookByURL(ctx context.Context, url string) (Webhook, bool, error)
+	// UpdateWebhookTrigger changes the minTriggerValue of the webhook with the given URL
+	UpdateWebhookTrigger(ctx context.Context, url string, minTriggerValue int32) error
+	// DeleteWebhook removes the webhook with the given ID
+	DeleteWebhook(ctx context.Context, id string) error
+	// AllWebhooks returns every registered webhook
+	AllWebhooks(ctx context.Context) ([]Webhook, error)
+}
+
+// storeCloser is implemented by the stores holding a connection or a file that must be released when the service stops
+type storeCloser interface {
+	Close(ctx context.Context) error
+}
+
+// The stores used by the handlers. They default to the in-memory store so the handlers
+// (and the tests) work without a database, main() replaces them with the configured backend
+var tracksDB TrackStore = newMemoryStore()
+var webhooksDB WebhookStore = newMemoryStore()
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Runs the same checks against any TrackStore implementation
+func testTrackStore(t *testing.T, store TrackStore) {
+	ctx := context.Background()
+
+	first := tracks{UniqueID: "1", Pilot: "Pilot One", URL: "http://example.com/one.igc", TimeRecorded: time.Now()}
+	second := tracks{UniqueID: "2", Pilot: "Pilot Two", URL: "http://example.com/two.igc", TimeRecorded: time.Now(), ContentHash: "abc"}
+	uploaded := tracks{UniqueID: "3", Pilot: "Pilot Three", TimeRecorded: time.Now(), ContentHash: "def"}
+
+	if err := store.InsertTrack(ctx, first); err != nil {
+		t.Fatalf("Error inserting the track, %s", err)
+	}
+	if err := store.InsertTrack(ctx, second); err != nil {
+		t.Fatalf("Error inserting the track, %s", err)
+	}
+	if err := store.InsertTrack(ctx, uploaded); err != nil {
+		t.Fatalf("Error inserting the track, %s", err)
+	}
+
+	track, found, err := store.TrackByID(ctx, "2")
+	if err != nil || !found || track.Pilot != "Pilot Two" {
+		t.Errorf("Expected to find track 2, got %v %v %v", track, found, err)
+	}
+
+	track, found, err = store.TrackByURL(ctx, "http://example.com/one.igc")
+	if err != nil || !found || track.UniqueID != "1" {
+		t.Errorf("Expected to find track 1 by URL, got %v %v %v", track, found, err)
+	}
+
+	track, found, err = store.TrackByHash(ctx, "def")
+	if err != nil || !found || track.UniqueID != "3" {
+		t.Errorf("Expected to find track 3 by hash, got %v %v %v", track, found, err)
+	}
+
+	// Uploaded tracks have no URL, they must not match each other
+	_, found, _ = store.TrackByURL(ctx, "")
+	if found {
+		t.Error("No track should be found for an empty URL")
+	}
+	_, found, _ = store.TrackByHash(ctx, "")
+	if found {
+		t.Error("No track should be found for an empty hash")
+	}
+
+	uploaded.Pilot = "Pilot Three Updated"
+	if err := store.UpdateTrack(ctx, uploaded); err != nil {
+		t.Errorf("Error updating the track, %s", err)
+	}
+	track, _, _ = store.TrackByHash(ctx, "def")
+	if track.Pilot != "Pilot Three Updated" {
+		t.Errorf("Expected the updated track, got %v", track)
+	}
+
+	second.URL, second.ContentHash = "http://example.com/moved.igc", "abd"
+	if err := store.UpdateTrack(ctx, second); err != nil {
+		t.Errorf("Error updating the track, %s", err)
+	}
+	if _, found, _ := store.TrackByURL(ctx, "http://example.com/two.igc"); found {
+		t.Error("The old URL should not find the updated track")
+	}
+	if _, found, _ := store.TrackByHash(ctx, "abc"); found {
+		t.Error("The old hash should not find the updated track")
+	}
+	track, found, _ = store.TrackByURL(ctx, "http://example.com/moved.igc")
+	if !found || track.UniqueID != "2" {
+		t.Errorf("Expected to find track 2 by its new URL, got %v %v", track, found)
+	}
+	track, found, _ = store.TrackByHash(ctx, "abd")
+	if !found || track.UniqueID != "2" {
+		t.Errorf("Expected to find track 2 by its new hash, got %v %v", track, found)
+	}
+
+	data := trackData{UniqueID: "3", IGC: []byte{1, 2, 3}, Fixes: []fix{{Time: time.Date(2017, 8, 9, 10, 0, 0, 0, time.UTC), Lat: 47.5, Lon: 5.1, GPSAltitude: 300, PressureAltitude: 290}}}
+	if err := store.SaveTrackData(ctx, data); err != nil {
+		t.Errorf("Error saving the track data, %s", err)
+	}
+	resData, found, err := store.TrackData(ctx, "3")
+	if err != nil || !found || len(resData.Fixes) != 1 || !resData.Fixes[0].Time.Equal(data.Fixes[0].Time) ||
+		resData.Fixes[0].Lat != 47.5 || string(resData.IGC) != string(data.IGC) {
+		t.Errorf("Expected the data of track 3, got %v %v %v", resData, found, err)
+	}
+	_, found, _ = store.TrackData(ctx, "1")
+	if found {
+		t.Error("Track 1 should have no data")
+	}
+
+	_, found, _ = store.TrackByID(ctx, "4")
+	if found {
+		t.Error("Track 4 should not exist")
+	}
+
+	removed := tracks{UniqueID: "4", URL: "http://example.com/four.igc", ContentHash: "ghi"}
+	if err := store.InsertTrack(ctx, removed); err != nil {
+		t.Fatalf("Error inserting the track, %s", err)
+	}
+	store.SaveTrackData(ctx, trackData{UniqueID: "4", IGC: []byte{4}})
+	if err := store.DeleteTrack(ctx, "4"); err != nil {
+		t.Errorf("Error deleting the track, %s", err)
+	}
+	if _, found, _ := store.TrackByID(ctx, "4"); found {
+		t.Error("Track 4 should have been deleted")
+	}
+	if _, found, _ := store.TrackByURL(ctx, removed.URL); found {
+		t.Error("The URL of the deleted track should not find it")
+	}
+	if _, found, _ := store.TrackByHash(ctx, removed.ContentHash); found {
+		t.Error("The hash of the deleted track should not find it")
+	}
+	if _, found, _ := store.TrackData(ctx, "4"); found {
+		t.Error("The data should be deleted with the track")
+	}
+	if err := store.DeleteTrack(ctx, "4"); err != nil {
+		t.Errorf("Deleting a missing track should do nothing, got %s", err)
+	}
+
+	ids, _ := store.TrackIDs(ctx)
+	if len(ids) != 3 || ids[0] != "1" || ids[1] != "2" || ids[2] != "3" {
+		t.Errorf("Expected ids [1 2 3], got %v", ids)
+	}
+
+	count, _ := store.CountTracks(ctx)
+	if count != 3 {
+		t.Errorf("Expected 3 tracks, got %d", count)
+	}
+
+	deleted, err := store.DeleteAllTracks(ctx)
+	if err != nil || deleted != 3 {
+		t.Errorf("Expected 3 tracks to be deleted, got %d %v", deleted, err)
+	}
+
+	_, found, _ = store.TrackByHash(ctx, "def")
+	if found {
+		t.Error("The hash index should be emptied with the tracks")
+	}
+	_, found, _ = store.TrackData(ctx, "3")
+	if found {
+		t.Error("The track data should be deleted with the tracks")
+	}
+
+	allTracks, _ := store.AllTracks(ctx)
+	if len(allTracks) != 0 {
+		t.Errorf("Expected no tracks, got %d", len(allTracks))
+	}
+}
+
+// Runs the same checks against any WebhookStore implementation
+func testWebhookStore(t *testing.T, store WebhookStore) {
+	ctx := context.Background()
+
+	webhook := Webhook{WebhookURL: "http://example.com/hook", MinTriggerValue: 1, WebhookID: "1"}
+
+	if err := store.InsertWebhook(ctx, webhook); err != nil {
+		t.Fatalf("Error inserting the webhook, %s", err)
+	}
+
+	if err := store.UpdateWebhookTrigger(ctx, webhook.WebhookURL, 3); err != nil {
+		t.Errorf("Error updating the webhook, %s", err)
+	}
+
+	found, ok, _ := store.WebhookByURL(ctx, webhook.WebhookURL)
+	if !ok || found.MinTriggerValue != 3 {
+		t.Errorf("Expected minTriggerValue 3, got %v", found)
+	}
+
+	if err := store.DeleteWebhook(ctx, "1"); err != nil {
+		t.Errorf("Error deleting the webhook, %s", err)
+	}
+
+	_, ok, _ = store.WebhookByID(ctx, "1")
+	if ok {
+		t.Error("Webhook should have been deleted")
+	}
+
+	allWebhooks, _ := store.AllWebhooks(ctx)
+	if len(allWebhooks) != 0 {
+		t.Errorf("Expected no webhooks, got %d", len(allWebhooks))
+	}
+}
+
+func Test_memoryStore(t *testing.T) {
+	testTrackStore(t, newMemoryStore())
+	testWebhookStore(t, newMemoryStore())
+}
+
+func Test_memoryStore_DuplicateID(t *testing.T) {
+	store := newMemoryStore()
+
+	store.InsertTrack(context.Background(), tracks{UniqueID: "1"})
+	if err := store.InsertTrack(context.Background(), tracks{UniqueID: "1"}); err != errDuplicateID {
+		t.Errorf("Expected errDuplicateID, got %v", err)
+	}
+}
+
+// Checks that an IDAllocator never hands out the same ID twice, even with concurrent callers
+func testIDAllocator(t *testing.T, store IDAllocator) {
+	ctx := context.Background()
+
+	const workers, perWorker = 8, 25
+	results := make(chan int64, workers*perWorker)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				id, err := store.NextID(ctx, sequenceTracks)
+				if err != nil {
+					t.Errorf("Error allocating an ID, %s", err)
+					return
+				}
+				results <- id
+			}
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	seen := map[int64]bool{}
+	for id := range results {
+		if seen[id] {
+			t.Errorf("ID %d was handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != workers*perWorker {
+		t.Errorf("Expected %d IDs, got %d", workers*perWorker, len(seen))
+	}
+
+	// The sequences are independent
+	if id, _ := store.NextID(ctx, sequenceWebhooks); id != 1 {
+		t.Errorf("Expected the webhooks sequence to start at 1, got %d", id)
+	}
+}
+
+func Test_memoryStore_NextID(t *testing.T) {
+	testIDAllocator(t, newMemoryStore())
+}
+
+func Test_maxNumericID(t *testing.T) {
+	if max := maxNumericID([]string{"12", "abc", "977", "3"}); max != 977 {
+		t.Errorf("Expected 977, got %d", max)
+	}
+	if max := maxNumericID(nil); max != 0 {
+		t.Errorf("Expected 0, got %d", max)
+	}
+}
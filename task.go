@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	igc "github.com/marni/goigc"
+)
+
+// *** DECLARED TASK *** //
+
+// Types of the points of a task, in the order they are flown
+const (
+	taskStart     = "start"
+	taskTurnpoint = "turnpoint"
+	taskFinish    = "finish"
+)
+
+// taskPoint is a point declared in the C-records of the file
+type taskPoint struct {
+	Name string  `json:"name"`
+	Lat  float64 `json:"lat"` // Degrees
+	Lon  float64 `json:"lon"` // Degrees
+}
+
+// declaredTask is the task the pilot declared in the flight recorder before the flight
+type declaredTask struct {
+	DeclaredAt  time.Time   `json:"declared_at"` // Zero when the recorder didn't set it
+	Date        time.Time   `json:"date"`        // Day of the flight the task was declared for
+	Number      int         `json:"number"`
+	Description string      `json:"description"`
+	Takeoff     taskPoint   `json:"takeoff"`
+	Start       taskPoint   `json:"start"`
+	Turnpoints  []taskPoint `json:"turnpoints"`
+	Finish      taskPoint   `json:"finish"`
+	Landing     taskPoint   `json:"landing"`
+}
+
+// taskPointResult tells if and when the pilot reached a point of the task
+type taskPointResult struct {
+	Type        string     `json:"type"`
+	Name        string     `json:"name"`
+	Lat         float64    `json:"lat"`
+	Lon         float64    `json:"lon"`
+	LegDistance float64    `json:"leg_distance"` // Km from the center of the previous point
+	Reached     bool       `json:"reached"`
+	Time        *time.Time `json:"time,omitempty"` // First fix in the cylinder, or the last one before leaving it for the start
+}
+
+// taskResult is the analysis of the declared task against the flight
+type taskResult struct {
+	Task                 declaredTask      `json:"task"`
+	Radius               float64           `json:"radius"`                 // m, of the cylinders around the points
+	DeclaredBeforeFlight bool              `json:"declared_before_flight"` // A task declared after the takeoff doesn't count
+	Points               []taskPointResult `json:"points"`
+	Distance             float64           `json:"distance"` // Km between the centers of the points
+	Completed            bool              `json:"completed"`
+	Duration             int64             `json:"duration,omitempty"` // Seconds from the start to the finish
+	Speed                float64           `json:"speed,omitempty"`    // Km/h from the start to the finish
+}
+
+// Returns true when the point has coordinates, the recorders write zeros for the points that were not declared
+func (p taskPoint) isSet() bool {
+	return p.Lat != 0 || p.Lon != 0
+}
+
+// Returns the point as a fix, to measure distances
+func (p taskPoint) position() fix {
+	return fix{Lat: p.Lat, Lon: p.Lon}
+}
+
+// Returns true when the file declared a task, with at least a start and a finish
+func (t declaredTask) isDeclared() bool {
+	return t.Start.isSet() && t.Finish.isSet()
+}
+
+func newTaskPoint(point igc.Point) taskPoint {
+	return taskPoint{
+		Name: strings.TrimSpace(point.Description),
+		Lat:  point.Lat.Degrees(),
+		Lon:  point.Lng.Degrees(),
+	}
+}
+
+// Converts the task parsed from the C-records, it is empty when the file has none
+func newDeclaredTask(task igc.Task) declaredTask {
+	declared := declaredTask{
+		DeclaredAt:  task.DeclarationDate,
+		Date:        task.Date,
+		Number:      task.Number,
+		Description: strings.TrimSpace(task.Description),
+		Takeoff:     newTaskPoint(task.Takeoff),
+		Start:       newTaskPoint(task.Start),
+		Turnpoints:  []taskPoint{},
+		Finish:      newTaskPoint(task.Finish),
+		Landing:     newTaskPoint(task.Landing),
+	}
+	for _, point := range task.Turnpoints {
+		declared.Turnpoints = append(declared.Turnpoints, newTaskPoint(point))
+	}
+	if !declared.isDeclared() {
+		return declaredTask{}
+	}
+	return declared
+}
+
+// Returns true when the fix is inside the cylinder of radius meters around the point
+func inCylinder(f fix, point taskPoint, radius float64) bool {
+	return fixDistance(f, point.position())*1000 <= radius
+}
+
+// Checks whether the pilot flew the task: every cylinder reached in order, from the start to the finish
+func checkTask(task declaredTask, fixes []fix, radius float64, takeoff time.Time) taskResult {
+	result := taskResult{
+		Task:                 task,
+		Radius:               radius,
+		DeclaredBeforeFlight: !task.DeclaredAt.IsZero() && (takeoff.IsZero() || task.DeclaredAt.Before(takeoff)),
+		Points:               []taskPointResult{},
+	}
+
+	route := []taskPointResult{{Type: taskStart, Name: task.Start.Name, Lat: task.Start.Lat, Lon: task.Start.Lon}}
+	for _, point := range task.Turnpoints {
+		if point.isSet() {
+			route = append(route, taskPointResult{Type: taskTurnpoint, Name: point.Name, Lat: point.Lat, Lon: point.Lon})
+		}
+	}
+	route = append(route, taskPointResult{Type: taskFinish, Name: task.Finish.Name, Lat: task.Finish.Lat, Lon: task.Finish.Lon})
+
+	// Every point is searched after the one before it
+	reached := make([]int, len(route))
+	next := 0
+	for k := range route {
+		point := taskPoint{Name: route[k].Name, Lat: route[k].Lat, Lon: route[k].Lon}
+		if k > 0 {
+			previous := taskPoint{Lat: route[k-1].Lat, Lon: route[k-1].Lon}
+			leg := fixDistance(previous.position(), point.position())
+			route[k].LegDistance = math.Round(leg*100) / 100
+			result.Distance += leg
+		}
+
+		reached[k] = -1
+		if k > 0 && reached[k-1] < 0 {
+			continue
+		}
+		for i := next; i < len(fixes); i++ {
+			if inCylinder(fixes[i], point, radius) {
+				reached[k], next = i, i
+				break
+			}
+		}
+	}
+
+	// The race starts when leaving the start cylinder, the last time before the first turnpoint
+	if reached[0] >= 0 && len(route) > 1 && reached[1] >= 0 {
+		for i := reached[1]; i > reached[0]; i-- {
+			if inCylinder(fixes[i], task.Start, radius) {
+				reached[0] = i
+				break
+			}
+		}
+	}
+
+	result.Completed = true
+	for k := range route {
+		if reached[k] >= 0 {
+			route[k].Reached = true
+			t := fixes[reached[k]].Time
+			route[k].Time = &t
+		} else {
+			result.Completed = false
+		}
+	}
+	result.Points = route
+	result.Distance = math.Round(result.Distance*100) / 100
+
+	if result.Completed {
+		duration := fixes[reached[len(route)-1]].Time.Sub(fixes[reached[0]].Time)
+		result.Duration = int64(duration / time.Second)
+		if duration > 0 {
+			result.Speed = math.Round(result.Distance/duration.Hours()*100) / 100
+		}
+	}
+	return result
+}
+
+// Handles path: GET /api/track/<id>/task
+// Returns the task declared in the file and whether the pilot completed it
+// The radius of the cylinders can be set with ?radius=<meters>, it is config.Task.CylinderRadius otherwise
+func handlerTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	radius := config.Task.CylinderRadius
+	if param := r.URL.Query().Get("radius"); param != "" {
+		value, err := strconv.ParseFloat(param, 64)
+		if err != nil || value <= 0 {
+			http.Error(w, "400 - Bad Request, the radius must be a positive number of meters", http.StatusBadRequest)
+			return
+		}
+		radius = value
+	}
+
+	data, ok := requestedTrackData(w, r)
+	if !ok {
+		return
+	}
+	track, found, err := tracksDB.TrackByID(r.Context(), data.UniqueID)
+	if err != nil {
+		http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+		return
+	}
+	if !found || !track.Task.isDeclared() {
+		http.Error(w, "404 - The track has no declared task", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(checkTask(track.Task, data.Fixes, radius, track.TakeoffTime))
+}
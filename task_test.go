@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	igc "github.com/marni/goigc"
+)
+
+// Formats degrees like the IGC records: degrees, minutes and thousandths of minute, then the hemisphere
+func igcCoordinate(degrees float64, width int, positive, negative string) string {
+	hemisphere := positive
+	if degrees < 0 {
+		hemisphere, degrees = negative, -degrees
+	}
+	thousandths := int(math.Round(degrees * 60000))
+	return fmt.Sprintf("%0*d%05d%s", width, thousandths/60000, thousandths%60000, hemisphere)
+}
+
+// Returns an IGC file with the fixes as B-records, after the given records (eg. the C-records of a task)
+func encodeIGC(fixes []fix, records ...string) []byte {
+	var b strings.Builder
+	b.WriteString("AXXX001\r\n")
+	b.WriteString("HFDTE" + fixes[0].Time.Format("020106") + "\r\n")
+	for _, record := range records {
+		b.WriteString(record + "\r\n")
+	}
+	for _, f := range fixes {
+		fmt.Fprintf(&b, "B%s%s%sA%05d%05d\r\n", f.Time.Format("150405"),
+			igcCoordinate(f.Lat, 2, "N", "S"), igcCoordinate(f.Lon, 3, "E", "W"), f.PressureAltitude, f.GPSAltitude)
+	}
+	return []byte(b.String())
+}
+
+// Returns the C-record of a point at the given km north and east of 46, 7
+func taskRecord(north, east float64, name string) string {
+	lat, lon := offsetKm(46, 7, north, east)
+	return "C" + igcCoordinate(lat, 2, "N", "S") + igcCoordinate(lon, 3, "E", "W") + name
+}
+
+// A triangle of 10 km legs, flown by routeThrough and declared the day before
+var (
+	triangleRoute = [][2]float64{{0, 0}, {10, 0}, {5, 8.66}, {0, 0.5}}
+	triangleTask  = []string{
+		"C130718080000140718000102Triangle",
+		"C0000000N00000000ETAKEOFF",
+		taskRecord(0, 0, "START"),
+		taskRecord(10, 0, "TP1"),
+		taskRecord(5, 8.66, "TP2"),
+		taskRecord(0, 0.5, "GOAL"),
+		"C0000000N00000000ELANDING",
+	}
+)
+
+func Test_newDeclaredTask(t *testing.T) {
+	parsed, err := igc.Parse(string(encodeIGC(routeThrough(triangleRoute...), triangleTask...)))
+	if err != nil {
+		t.Fatalf("Error parsing the file, %s", err)
+	}
+
+	task := newDeclaredTask(parsed.Task)
+	if !task.isDeclared() || task.Description != "Triangle" || task.Number != 1 || len(task.Turnpoints) != 2 {
+		t.Fatalf("Unexpected task %+v", task)
+	}
+	if task.Turnpoints[0].Name != "TP1" || task.Finish.Name != "GOAL" || task.Takeoff.isSet() {
+		t.Errorf("Unexpected points %+v", task)
+	}
+	if !task.DeclaredAt.Equal(time.Date(2018, 7, 13, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the declaration time from the C-record, got %s", task.DeclaredAt)
+	}
+
+	// The sample file has no task
+	parsed, _ = igc.Parse(string(readSampleIGC(t)))
+	if task := newDeclaredTask(parsed.Task); task.isDeclared() {
+		t.Errorf("Expected no task in the sample file, got %+v", task)
+	}
+}
+
+func Test_checkTask(t *testing.T) {
+	parsed, _ := igc.Parse(string(encodeIGC(routeThrough(triangleRoute...), triangleTask...)))
+	task := newDeclaredTask(parsed.Task)
+	fixes := routeThrough(triangleRoute...)
+
+	result := checkTask(task, fixes, 450, fixes[0].Time)
+	if !result.Completed || !result.DeclaredBeforeFlight || len(result.Points) != 4 {
+		t.Fatalf("Expected the task to be completed, got %+v", result)
+	}
+	for _, point := range result.Points {
+		if !point.Reached || point.Time == nil {
+			t.Errorf("Expected %s to be reached, got %+v", point.Name, point)
+		}
+	}
+	// The fixes are 100 m apart, the last one in the start cylinder is 400 m from the first fix
+	if !result.Points[0].Time.Equal(fixes[4].Time) {
+		t.Errorf("Expected the start when leaving the cylinder at %s, got %s", fixes[4].Time, result.Points[0].Time)
+	}
+	if math.Abs(result.Distance-29.5) > 0.1 || math.Abs(result.Points[1].LegDistance-10) > 0.05 {
+		t.Errorf("Expected a task of about 29.5 km with a first leg of 10 km, got %f and %f", result.Distance, result.Points[1].LegDistance)
+	}
+	expectedSpeed := result.Distance / (time.Duration(result.Duration) * time.Second).Hours()
+	if result.Duration <= 0 || math.Abs(result.Speed-expectedSpeed) > 0.01 {
+		t.Errorf("Unexpected duration %d and speed %f", result.Duration, result.Speed)
+	}
+
+	// Declared after the takeoff
+	if result := checkTask(task, fixes, 400, task.DeclaredAt.Add(-time.Hour)); result.DeclaredBeforeFlight {
+		t.Errorf("Expected the task declared after the takeoff to be reported")
+	}
+}
+
+func Test_checkTask_Missed(t *testing.T) {
+	// The pilot cut the second turnpoint by 1 km
+	fixes := routeThrough([2]float64{0, 0}, [2]float64{10, 0}, [2]float64{5, 7.66}, [2]float64{0, 0.5})
+	parsed, _ := igc.Parse(string(encodeIGC(fixes, triangleTask...)))
+
+	result := checkTask(newDeclaredTask(parsed.Task), fixes, 400, time.Time{})
+	if result.Completed || result.Speed != 0 {
+		t.Errorf("Expected the task not to be completed, got %+v", result)
+	}
+	if !result.Points[1].Reached || result.Points[2].Reached || result.Points[3].Reached {
+		t.Errorf("Expected only the start and the first turnpoint to be reached, got %+v", result.Points)
+	}
+
+	// A bigger cylinder includes the turnpoint
+	if result := checkTask(newDeclaredTask(parsed.Task), fixes, 1500, time.Time{}); !result.Completed {
+		t.Errorf("Expected the task to be completed with 1.5 km cylinders, got %+v", result.Points)
+	}
+}
+
+func Test_handlerTask(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", encodeIGC(routeThrough(triangleRoute...), triangleTask...))
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	router := newRouter()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/task", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+	var result taskResult
+	if err := json.NewDecoder(rec.Body).Decode(&result); err != nil {
+		t.Fatalf("Error decoding the task, %s", err)
+	}
+	if !result.Completed || result.Radius != config.Task.CylinderRadius || result.Task.Description != "Triangle" {
+		t.Errorf("Unexpected task result %+v", result)
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/task?radius=50", nil))
+	json.NewDecoder(rec.Body).Decode(&result)
+	if result.Radius != 50 {
+		t.Errorf("Expected the radius from the query, got %f", result.Radius)
+	}
+
+	for path, expected := range map[string]int{
+		"/paragliding/api/track/2/task":           http.StatusNotFound, // No task in the sample
+		"/paragliding/api/track/3/task":           http.StatusNotFound,
+		"/paragliding/api/track/1/task?radius=-1": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != expected {
+			t.Errorf("%s: expected %d, got %d", path, expected, rec.Code)
+		}
+	}
+}
+
+func Test_backfillTrackData_Task(t *testing.T) {
+	useMemoryTracks(t)
+	ctx := context.Background()
+	content := encodeIGC(routeThrough(triangleRoute...), triangleTask...)
+	parsed, _ := igc.Parse(string(content))
+
+	// A track analysed before the tasks were kept
+	data, _ := newTrackData("1", content, parsed)
+	tracksDB.InsertTrack(ctx, tracks{UniqueID: "1", AnalysisVersion: trackAnalysisVersion - 1})
+	tracksDB.SaveTrackData(ctx, data)
+
+	report, err := backfillTrackData(ctx)
+	if err != nil || len(report.Analysed) != 1 {
+		t.Fatalf("Expected the track to be analysed again, got %+v %v", report, err)
+	}
+	track, _, _ := tracksDB.TrackByID(ctx, "1")
+	if !track.Task.isDeclared() || track.AnalysisVersion != trackAnalysisVersion {
+		t.Errorf("Expected the task to be read from the stored file, got %+v", track)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+)
+
+// *** THERMAL DETECTION *** //
+
+// Thresholds used to tell circling in a thermal from gliding
+const (
+	// A paraglider circles in 20 to 30 seconds, about 15 °/s, a glide barely turns
+	minCirclingTurnRate = 6.0 // °/s
+	// The turn rate is averaged over this window, the heading of a single segment is noisy
+	circlingSmoothing = 10 * time.Second
+	// Straightening for a shorter time to center the thermal doesn't leave it
+	maxCirclingGap = 20 * time.Second
+)
+
+// flightPoint is a fix where something happened, eg. the pilot entered or left a thermal
+type flightPoint struct {
+	Time     time.Time `json:"time"`
+	Lat      float64   `json:"lat"`
+	Lon      float64   `json:"lon"`
+	Altitude int64     `json:"altitude"` // m, from the GPS
+}
+
+// thermal is a climb the pilot circled in
+type thermal struct {
+	Entry        flightPoint `json:"entry"`
+	Exit         flightPoint `json:"exit"`
+	Duration     int64       `json:"duration"`      // Seconds
+	AltitudeGain int64       `json:"altitude_gain"` // m
+	AverageClimb float64     `json:"average_climb"` // m/s
+	Direction    string      `json:"direction"`     // "left" or "right"
+	Turns        int         `json:"turns"`         // Full circles
+}
+
+// thermalSummary compares the thermals of the flight with the whole flight
+type thermalSummary struct {
+	Count           int     `json:"count"`
+	AverageClimb    float64 `json:"average_climb"`    // m/s, in all the thermals
+	ThermallingTime int64   `json:"thermalling_time"` // Seconds
+	Percentage      float64 `json:"percentage"`       // Of the airborne time spent thermalling
+}
+
+// Returns the heading in degrees (0 is north, 90 east) from a to b
+func fixBearing(a, b fix) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	y := math.Sin(dLon) * math.Cos(lat2)
+	x := math.Cos(lat1)*math.Sin(lat2) - math.Sin(lat1)*math.Cos(lat2)*math.Cos(dLon)
+	return math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+}
+
+// Returns the heading change in degrees between -180 and 180, positive when turning right
+func headingChange(from, to float64) float64 {
+	return math.Mod(to-from+540, 360) - 180
+}
+
+// Returns, for every fix, the heading change in degrees between the segment arriving to it and the one leaving it
+// The first and last fixes don't turn, and a fix on the spot keeps the heading it arrived with
+func headingChanges(fixes []fix) []float64 {
+	changes := make([]float64, len(fixes))
+	if len(fixes) < 3 {
+		return changes
+	}
+
+	headings := make([]float64, len(fixes)) // headings[i] is the segment from i-1 to i
+	for i := 1; i < len(fixes); i++ {
+		if fixDistance(fixes[i-1], fixes[i]) < 0.001 && i > 1 {
+			headings[i] = headings[i-1]
+			continue
+		}
+		headings[i] = fixBearing(fixes[i-1], fixes[i])
+	}
+	for i := 2; i < len(fixes); i++ {
+		changes[i-1] = headingChange(headings[i-1], headings[i])
+	}
+	return changes
+}
+
+// Returns, for every fix, true when the pilot was circling around it
+// The turn rate is averaged over circlingSmoothing, centered on the fix
+func circlingFixes(fixes []fix, changes []float64) []bool {
+	circling := make([]bool, len(fixes))
+
+	// sums[i] is the turning of the fixes before i
+	sums := make([]float64, len(fixes)+1)
+	for i, change := range changes {
+		sums[i+1] = sums[i] + change
+	}
+
+	lo, hi := 0, 0
+	for i := range fixes {
+		for fixes[i].Time.Sub(fixes[lo].Time) > circlingSmoothing/2 {
+			lo++
+		}
+		for hi+1 < len(fixes) && fixes[hi+1].Time.Sub(fixes[i].Time) <= circlingSmoothing/2 {
+			hi++
+		}
+		span := fixes[hi].Time.Sub(fixes[lo].Time).Seconds()
+		if span <= 0 {
+			continue
+		}
+		rate := (sums[hi+1] - sums[lo]) / span
+		circling[i] = math.Abs(rate) >= minCirclingTurnRate
+	}
+	return circling
+}
+
+// Returns true when the pilot turned at the fix as fast as when circling
+func isTurning(fixes []fix, changes []float64, i int) bool {
+	if i == 0 || i == len(fixes)-1 {
+		return false
+	}
+	dt := fixes[i+1].Time.Sub(fixes[i-1].Time).Seconds() / 2
+	return dt > 0 && math.Abs(changes[i])/dt >= minCirclingTurnRate
+}
+
+// Finds the thermals in the fixes of a flight: the pilot circled for at least a full turn and climbed
+func detectThermals(fixes []fix) []thermal {
+	thermals := []thermal{}
+	changes := headingChanges(fixes)
+	circling := circlingFixes(fixes, changes)
+	altitudes := varioAltitudes(fixes)
+
+	// Runs of circling fixes, joined when the gap between them is short
+	var runs []segmentRun
+	for i := range fixes {
+		if !circling[i] {
+			continue
+		}
+		if len(runs) > 0 {
+			last := &runs[len(runs)-1]
+			if last.to == i-1 || fixes[i].Time.Sub(fixes[last.to].Time) <= maxCirclingGap {
+				last.to = i
+				continue
+			}
+		}
+		runs = append(runs, segmentRun{from: i, to: i, flying: true})
+	}
+
+	for _, run := range runs {
+		// The averaged turn rate reaches a few seconds into the glides around the thermal
+		for run.from < run.to && !isTurning(fixes, changes, run.from) {
+			run.from++
+		}
+		for run.to > run.from && !isTurning(fixes, changes, run.to) {
+			run.to--
+		}
+
+		turning := 0.0
+		for i := run.from; i <= run.to; i++ {
+			turning += changes[i]
+		}
+		turns := int(math.Abs(turning) / 360)
+		duration := run.duration(fixes)
+		gain := altitudes[run.to] - altitudes[run.from]
+		if turns < 1 || gain <= 0 || duration <= 0 {
+			continue
+		}
+
+		direction := "right"
+		if turning < 0 {
+			direction = "left"
+		}
+		thermals = append(thermals, thermal{
+			Entry:        newFlightPoint(fixes[run.from]),
+			Exit:         newFlightPoint(fixes[run.to]),
+			Duration:     int64(duration / time.Second),
+			AltitudeGain: int64(math.Round(gain)),
+			AverageClimb: math.Round(gain/duration.Seconds()*100) / 100,
+			Direction:    direction,
+			Turns:        turns,
+		})
+	}
+	return thermals
+}
+
+func newFlightPoint(f fix) flightPoint {
+	return flightPoint{Time: f.Time, Lat: f.Lat, Lon: f.Lon, Altitude: f.altitude()}
+}
+
+// Sums up the thermals of a flight that was in the air for the airborne time
+func summariseThermals(thermals []thermal, airborne time.Duration) thermalSummary {
+	summary := thermalSummary{Count: len(thermals)}
+	gain := int64(0)
+	for _, t := range thermals {
+		summary.ThermallingTime += t.Duration
+		gain += t.AltitudeGain
+	}
+	if summary.ThermallingTime > 0 {
+		summary.AverageClimb = math.Round(float64(gain)/float64(summary.ThermallingTime)*100) / 100
+	}
+	if airborne > 0 {
+		summary.Percentage = math.Round(float64(summary.ThermallingTime)/airborne.Seconds()*1000) / 10
+	}
+	return summary
+}
+
+// Handles path: GET /api/track/<id>/thermals
+// Returns the thermals the pilot circled in during the flight, and a summary of them
+func handlerThermals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	data, ok := requestedTrackData(w, r)
+	if !ok {
+		return
+	}
+
+	fixes := data.Fixes
+	flight := detectFlight(fixes)
+	thermals := []thermal{}
+	if flight.Takeoff >= 0 {
+		thermals = detectThermals(fixes[flight.Takeoff : flight.Landing+1])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Thermals []thermal      `json:"thermals"`
+		Summary  thermalSummary `json:"summary"`
+	}{thermals, summariseThermals(thermals, flight.AirborneTime)})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Appends to fixes one fix per second for the given time, turning at the rate (°/s, positive to the right),
+// flying at the speed (km/h) and climbing at the rate (m/s). It starts from the heading of the last two fixes
+func fly(fixes []fix, d time.Duration, turnRate float64, speed float64, climb float64) []fix {
+	last := fixes[len(fixes)-1]
+	heading := fixBearing(fixes[len(fixes)-2], last)
+	altitude := float64(last.GPSAltitude)
+	step := speed / 3600 / earthRadius * 180 / math.Pi // Degrees of latitude per second
+	for i := 0; i < int(d/time.Second); i++ {
+		heading += turnRate
+		altitude += climb
+		last = fix{
+			Time:        last.Time.Add(time.Second),
+			Lat:         last.Lat + step*math.Cos(heading*math.Pi/180),
+			Lon:         last.Lon + step*math.Sin(heading*math.Pi/180)/math.Cos(last.Lat*math.Pi/180),
+			GPSAltitude: int64(math.Round(altitude)),
+		}
+		fixes = append(fixes, last)
+	}
+	return fixes
+}
+
+func Test_headingChange(t *testing.T) {
+	testCases := []struct{ from, to, expected float64 }{
+		{10, 30, 20},
+		{350, 10, 20},
+		{10, 350, -20},
+		{90, 270, -180},
+	}
+	for _, tc := range testCases {
+		if change := headingChange(tc.from, tc.to); math.Abs(change-tc.expected) > 1e-9 {
+			t.Errorf("From %f to %f: expected %f, got %f", tc.from, tc.to, tc.expected, change)
+		}
+	}
+}
+
+func Test_detectThermals(t *testing.T) {
+	fixes := moveNorth(nil, 5*time.Minute, 30, -1)
+	entry := len(fixes) - 1
+	fixes = fly(fixes, 3*time.Minute, 14.4, 35, 2) // 7.2 turns to the right
+	fixes = fly(fixes, 3*time.Minute, 0, 35, -1.5)
+	fixes = fly(fixes, 2*time.Minute, -14.4, 35, 1.5) // 4.8 turns to the left
+	fixes = fly(fixes, 2*time.Minute, 0, 35, -1.5)
+	fixes = fly(fixes, time.Minute, 20, 40, -4) // Spiralling down, not a thermal
+	fixes = fly(fixes, 2*time.Minute, 0, 35, -1.5)
+
+	thermals := detectThermals(fixes)
+	if len(thermals) != 2 {
+		t.Fatalf("Expected 2 thermals, got %d: %+v", len(thermals), thermals)
+	}
+
+	first, second := thermals[0], thermals[1]
+	if first.Direction != "right" || first.Turns != 7 || second.Direction != "left" || second.Turns != 4 {
+		t.Errorf("Expected 7 turns right and 4 left, got %d %s and %d %s", first.Turns, first.Direction, second.Turns, second.Direction)
+	}
+	// The turn rate is averaged, the entry and exit can be a few seconds off
+	if math.Abs(first.Entry.Time.Sub(fixes[entry].Time).Seconds()) > 5 || math.Abs(float64(first.Duration)-180) > 10 {
+		t.Errorf("Expected the first thermal to start at %s for 180 s, got %s for %d s", fixes[entry].Time, first.Entry.Time, first.Duration)
+	}
+	if math.Abs(float64(first.AltitudeGain)-360) > 20 || math.Abs(first.AverageClimb-2) > 0.1 {
+		t.Errorf("Expected about 360 m gained at 2 m/s, got %d m at %f m/s", first.AltitudeGain, first.AverageClimb)
+	}
+	if math.Abs(second.AverageClimb-1.5) > 0.1 {
+		t.Errorf("Expected about 1.5 m/s in the second thermal, got %f", second.AverageClimb)
+	}
+}
+
+func Test_detectThermals_Straight(t *testing.T) {
+	if thermals := detectThermals(moveNorth(nil, 10*time.Minute, 30, 2)); len(thermals) != 0 {
+		t.Errorf("Expected no thermal when climbing straight, got %+v", thermals)
+	}
+}
+
+func Test_summariseThermals(t *testing.T) {
+	thermals := []thermal{{Duration: 100, AltitudeGain: 300}, {Duration: 200, AltitudeGain: 300}}
+	summary := summariseThermals(thermals, 20*time.Minute)
+	if summary.Count != 2 || summary.ThermallingTime != 300 || summary.AverageClimb != 2 || summary.Percentage != 25 {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if summary := summariseThermals(nil, 0); summary != (thermalSummary{}) {
+		t.Errorf("Expected an empty summary, got %+v", summary)
+	}
+}
+
+func Test_handlerThermals(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+
+	router := newRouter()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track/1/thermals", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	var response struct {
+		Thermals []thermal      `json:"thermals"`
+		Summary  thermalSummary `json:"summary"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+		t.Fatalf("Error decoding the thermals, %s", err)
+	}
+	if response.Summary.Count != len(response.Thermals) || response.Summary.Percentage > 100 {
+		t.Errorf("Unexpected summary %+v for %d thermals", response.Summary, len(response.Thermals))
+	}
+
+	for path, expected := range map[string]int{
+		"/paragliding/api/track/2/thermals":  http.StatusNotFound,
+		"/paragliding/api/track/a1/thermals": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != expected {
+			t.Errorf("%s: expected %d, got %d", path, expected, rec.Code)
+		}
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"net/http" //"html/template"
 	"strconv"
 	"strings"
@@ -76,8 +78,10 @@ func oldestNewerTimestamp(inputTS string, resultTracks []tracks) time.Time {
 }
 
 func tickerTimestamps(inputTS string) Timestamps {
-	conn := mongoConnect()
-	resultTracks := getAllTracks(conn)
+	resultTracks, err := tracksDB.AllTracks(context.Background())
+	if err != nil {
+		log.Println("Error reading the tracks for the ticker, ", err)
+	}
 
 	timestamps := Timestamps{}
 
@@ -88,6 +92,31 @@ func tickerTimestamps(inputTS string) Timestamps {
 	return timestamps
 }
 
+// Return track names
+// And also t_stop track
+func returnTracks(n int) (string, time.Time) {
+	var response string
+	var tStop time.Time
+
+	resultTracks, err := tracksDB.AllTracks(context.Background())
+	if err != nil {
+		log.Println("Error reading the tracks for the ticker, ", err)
+	}
+
+	for key, val := range resultTracks { // Go through the slice
+		response += `"` + val.UniqueID + `",`
+		if key == n-1 || key == len(resultTracks)-1 {
+			tStop = val.TimeRecorded
+			break
+		}
+	}
+
+	// Get rid of that last `,` of JSON will freak out
+	response = strings.TrimRight(response, ",")
+
+	return response, tStop
+}
+
 func handlerTickerLatest(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == http.MethodGet { // The request has to be of GET type
@@ -137,15 +166,14 @@ func handlerTicker(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// returnTracks returns the last element and the n number of tracks
-		trackArray, tStop := returnTracks(5)
+		trackArray, tStop := returnTracks(config.Ticker.PageSize)
 
 		// t_stop SHOULD BE ADDED HERE
 		response += `"t_stop": "` + tStop.Format("02.01.2006 15:04:05.000") + `",`
 
 		response += `"tracks":` + `[`
 
-		// THAT 5 SHOULD BE ABLE TO CHANGE DYNAMICALLY
-		response += trackArray // Maximum of 5 tracks
+		response += trackArray // Maximum of config.Ticker.PageSize tracks
 
 		response += `],`
 		response += `"processing":` + `"` + strconv.FormatFloat(float64(time.Since(processStart))/float64(time.Millisecond), 'f', 2, 64) + `ms"`
@@ -195,15 +223,14 @@ func handlerTickerTimestamp(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// returnTracks returns the last element and the n number of tracks
-		trackArray, tStop := returnTracks(5)
+		trackArray, tStop := returnTracks(config.Ticker.PageSize)
 
 		// t_stop SHOULD BE ADDED HERE
 		response += `"t_stop": "` + tStop.Format("02.01.2006 15:04:05.000") + `",`
 
 		response += `"tracks":` + `[`
 
-		// THAT 5 SHOULD BE ABLE TO CHANGE DYNAMICALLY
-		response += trackArray // Maximum of 5 tracks
+		response += trackArray // Maximum of config.Ticker.PageSize tracks
 
 		response += `],`
 
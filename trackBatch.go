@@ -0,0 +1,234 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// *** BATCH IMPORT *** //
+
+// Status of a file in the batch report
+const (
+	batchAdded     = "added"
+	batchDuplicate = "duplicate"
+	batchError     = "error"
+)
+
+// batchResult is the line of the batch report for one file
+type batchResult struct {
+	File    string   `json:"file"`
+	Status  string   `json:"status"`
+	ID      string   `json:"id,omitempty"`      // The new ID, or the ID of the track already stored for duplicates
+	Flights []string `json:"flights,omitempty"` // IDs of every flight when the file was split
+	Error   string   `json:"error,omitempty"`
+}
+
+// batchReport is the response of the batch import
+type batchReport struct {
+	Added []string      `json:"added"`
+	Files []batchResult `json:"files"`
+	split bool          // The files with several flights are split, see registerTrack
+}
+
+// batchURLs is the JSON body of a batch of URLs
+type batchURLs struct {
+	URLs []string `json:"urls"`
+}
+
+// Registers one file of the batch and adds the outcome to the report
+func (report *batchReport) register(ctx context.Context, name string, content []byte, srcURL string) {
+	track, duplicate, err := registerTrack(ctx, content, srcURL, report.split)
+	report.add(name, track, duplicate, err)
+}
+
+// Adds the outcome of the registration of a file to the report
+func (report *batchReport) add(name string, track tracks, duplicate bool, err error) {
+	switch {
+	case err != nil:
+		report.Files = append(report.Files, batchResult{File: name, Status: batchError, Error: err.Error()})
+	case duplicate:
+		report.Files = append(report.Files, batchResult{File: name, Status: batchDuplicate, ID: track.UniqueID, Flights: track.Flights})
+	default:
+		report.Files = append(report.Files, batchResult{File: name, Status: batchAdded, ID: track.UniqueID, Flights: track.Flights})
+		report.Added = append(report.Added, track.fileTrackIDs()...)
+	}
+}
+
+// Adds an error line to the report, for files that could not even be read
+func (report *batchReport) fail(name string, err error) {
+	report.Files = append(report.Files, batchResult{File: name, Status: batchError, Error: err.Error()})
+}
+
+// Returns true for the names of the IGC files, whatever the case of the extension
+func isIGCFile(name string) bool {
+	return strings.EqualFold(path.Ext(name), ".igc")
+}
+
+// Returns true for ZIP archives, by the name or by the content type of the part
+func isZIPFile(name string, contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return strings.EqualFold(path.Ext(name), ".zip") || mediaType == "application/zip" || mediaType == "application/x-zip-compressed"
+}
+
+// Registers every IGC file in the ZIP archive, the other files and the directories are ignored
+// archiveName prefixes the names in the report, so files from different archives can be told apart
+func (report *batchReport) registerZIP(ctx context.Context, archiveName string, archive []byte) {
+	reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		report.fail(archiveName, fmt.Errorf("not a valid ZIP archive: %s", err))
+		return
+	}
+
+	for _, file := range reader.File {
+		// Skipping the folders, and the metadata macOS adds to the archives it creates
+		if file.FileInfo().IsDir() || !isIGCFile(file.Name) || strings.HasPrefix(file.Name, "__MACOSX/") {
+			continue
+		}
+
+		name := file.Name
+		if archiveName != "" {
+			name = archiveName + "/" + file.Name
+		}
+
+		content, err := readZIPFile(file)
+		if err != nil {
+			report.fail(name, err)
+			continue
+		}
+		report.register(ctx, name, content, "")
+	}
+}
+
+// Reads a file of the archive, refusing the ones bigger than a single upload may be
+func readZIPFile(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > uint64(config.Upload.MaxSize) {
+		return nil, fmt.Errorf("the file is bigger than %d bytes", config.Upload.MaxSize)
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// The size in the header can't be trusted, the reader is limited as well
+	content, err := ioutil.ReadAll(io.LimitReader(reader, config.Upload.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > config.Upload.MaxSize {
+		return nil, fmt.Errorf("the file is bigger than %d bytes", config.Upload.MaxSize)
+	}
+	return content, nil
+}
+
+// Registers every file of a multipart form: IGC files, and ZIP archives of IGC files
+func (report *batchReport) registerMultipart(ctx context.Context, r *http.Request) error {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := part.FileName()
+		if name == "" {
+			// Not a file, just a form field
+			part.Close()
+			continue
+		}
+
+		content, err := ioutil.ReadAll(part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+
+		if isZIPFile(name, part.Header.Get("Content-Type")) {
+			report.registerZIP(ctx, name, content)
+		} else {
+			report.register(ctx, name, content, "")
+		}
+	}
+}
+
+// Handles path: POST /api/track/batch
+// Registers many tracks at once, the body can be:
+//   - a ZIP archive of IGC files (application/zip)
+//   - a multipart/form-data form with any number of IGC files and ZIP archives
+//   - a JSON list of URLs: {"urls": ["<url>", ...]}
+//
+// Returns a report with the outcome of every file. The webhooks are triggered once for the whole batch
+func handlerTrackBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, config.Upload.MaxBatchSize)
+	ctx := r.Context()
+	report := batchReport{Added: []string{}, Files: []batchResult{}, split: wantsSplit(r)}
+
+	// Even when the body is cut short the tracks registered so far are stored, the webhooks must hear about them
+	defer func() {
+		if len(report.Added) > 0 {
+			triggerWhenTrackIsAdded(report.Added)
+		}
+	}()
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "multipart/form-data":
+		if err := report.registerMultipart(ctx, r); err != nil {
+			http.Error(w, "400 - Bad Request, could not read the form: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+	case isZIPFile("", mediaType):
+		archive, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "400 - Bad Request, could not read the archive: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		report.registerZIP(ctx, "", archive)
+
+	case mediaType == "application/json" || mediaType == "":
+		urls := batchURLs{}
+		if err := json.NewDecoder(r.Body).Decode(&urls); err != nil {
+			http.Error(w, "400 - Bad Request, expected {\"urls\": [...]}: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, url := range urls.URLs {
+			report.registerURL(ctx, url)
+		}
+
+	default:
+		http.Error(w, "415 - Unsupported Media Type, send a ZIP archive, a multipart form or a JSON list of URLs", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// Fetches the IGC file at the URL and registers it, like POST /api/track does
+func (report *batchReport) registerURL(ctx context.Context, url string) {
+	track, duplicate, err := registerTrackURL(ctx, url, report.split)
+	report.add(url, track, duplicate, err)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// Creates a ZIP archive with the given files
+func zipArchive(t *testing.T, files map[string][]byte) []byte {
+	buf := &bytes.Buffer{}
+	writer := zip.NewWriter(buf)
+	for name, content := range files {
+		f, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("Error creating the archive, %s", err)
+		}
+		f.Write(content)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Error creating the archive, %s", err)
+	}
+	return buf.Bytes()
+}
+
+// Posts the body to handlerTrackBatch and decodes the report
+func postBatch(t *testing.T, contentType string, body []byte) (int, batchReport) {
+	ts := httptest.NewServer(http.HandlerFunc(handlerTrackBatch))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error making the POST request, %s", err)
+	}
+	defer resp.Body.Close()
+
+	report := batchReport{}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+			t.Fatalf("Error decoding the report, %s", err)
+		}
+	}
+	return resp.StatusCode, report
+}
+
+// Returns the line of the report for the file
+func reportFor(report batchReport, file string) batchResult {
+	for _, val := range report.Files {
+		if val.File == file {
+			return val
+		}
+	}
+	return batchResult{}
+}
+
+func Test_handlerTrackBatch_ZIP(t *testing.T) {
+	useMemoryTracks(t)
+	content := readSampleIGC(t)
+
+	archive := zipArchive(t, map[string][]byte{
+		"season/flight.igc":            content,
+		"season/copy.IGC":              content,
+		"season/broken.igc":            []byte("not an igc file"),
+		"season/readme.txt":            []byte("ignored"),
+		"__MACOSX/season/._flight.igc": []byte("ignored"),
+	})
+
+	status, report := postBatch(t, "application/zip", archive)
+	if status != http.StatusOK {
+		t.Fatalf("Expected StatusOK, got %d", status)
+	}
+	if len(report.Files) != 3 {
+		t.Fatalf("Expected a line for the 3 IGC files, got %+v", report.Files)
+	}
+
+	// The archive order decides which of the two copies is the duplicate
+	flight, copied := reportFor(report, "season/flight.igc"), reportFor(report, "season/copy.IGC")
+	if flight.ID != "1" || copied.ID != "1" || flight.Status == copied.Status {
+		t.Errorf("Expected one copy added and the other one duplicate of track 1, got %+v %+v", flight, copied)
+	}
+	if broken := reportFor(report, "season/broken.igc"); broken.Status != batchError || broken.Error == "" {
+		t.Errorf("Expected an error for the broken file, got %+v", broken)
+	}
+	if len(report.Added) != 1 || report.Added[0] != "1" {
+		t.Errorf("Expected track 1 to be added, got %v", report.Added)
+	}
+}
+
+func Test_handlerTrackBatch_Multipart(t *testing.T) {
+	useMemoryTracks(t)
+	content := readSampleIGC(t)
+
+	form := &bytes.Buffer{}
+	writer := multipart.NewWriter(form)
+	writer.WriteField("comment", "not a file")
+	part, _ := writer.CreateFormFile("files", "single.igc")
+	part.Write(content)
+	part, _ = writer.CreateFormFile("files", "club.zip")
+	part.Write(zipArchive(t, map[string][]byte{"again.igc": content}))
+	writer.Close()
+
+	status, report := postBatch(t, writer.FormDataContentType(), form.Bytes())
+	if status != http.StatusOK {
+		t.Fatalf("Expected StatusOK, got %d", status)
+	}
+
+	if res := reportFor(report, "single.igc"); res.Status != batchAdded || res.ID != "1" {
+		t.Errorf("Expected single.igc to be added as track 1, got %+v", res)
+	}
+	if res := reportFor(report, "club.zip/again.igc"); res.Status != batchDuplicate || res.ID != "1" {
+		t.Errorf("Expected club.zip/again.igc to be a duplicate of track 1, got %+v", res)
+	}
+}
+
+func Test_handlerTrackBatch_Invalid(t *testing.T) {
+	useMemoryTracks(t)
+
+	if status, _ := postBatch(t, "image/png", []byte("png")); status != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected StatusUnsupportedMediaType, got %d", status)
+	}
+
+	status, report := postBatch(t, "application/zip", []byte("not a zip"))
+	if status != http.StatusOK || len(report.Files) != 1 || report.Files[0].Status != batchError {
+		t.Errorf("Expected a report with the archive error, got %d %+v", status, report)
+	}
+}
+
+func Test_handlerTrackBatch_WebhookOncePerBatch(t *testing.T) {
+	useMemoryTracks(t)
+	previous := webhooksDB
+	webhooksDB = newMemoryStore()
+	defer func() { webhooksDB = previous }()
+
+	var mu sync.Mutex
+	var calls []string
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		mu.Lock()
+		calls = append(calls, r.PostForm.Get("content"))
+		mu.Unlock()
+	}))
+	defer hook.Close()
+
+	webhooksDB.InsertWebhook(context.Background(), Webhook{WebhookURL: hook.URL, MinTriggerValue: 1, WebhookID: "1"})
+
+	// Two different files, the second one is the sample without its last fix
+	content := readSampleIGC(t)
+	lines := strings.Split(strings.TrimRight(string(content), "\r\n"), "\n")
+	other := []byte(strings.Join(lines[:len(lines)-1], "\n"))
+
+	status, report := postBatch(t, "application/zip", zipArchive(t, map[string][]byte{"a.igc": content, "b.igc": other}))
+	if status != http.StatusOK || len(report.Added) != 2 {
+		t.Fatalf("Expected 2 tracks to be added, got %d %+v", status, report)
+	}
+
+	waitForWebhooks(context.Background())
+
+	if len(calls) != 1 {
+		t.Fatalf("Expected the webhook to be called once, got %d calls", len(calls))
+	}
+	if !strings.Contains(calls[0], "1, 2") {
+		t.Errorf("Expected the webhook to get both new tracks, got %s", calls[0])
+	}
+}
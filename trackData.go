@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	igc "github.com/marni/goigc"
+)
+
+// *** TRACK DATA *** //
+
+// fix is a single GPS position recorded by the flight recorder (a B-record)
+type fix struct {
+	Time             time.Time `json:"time"`
+	Lat              float64   `json:"lat"` // Degrees
+	Lon              float64   `json:"lon"` // Degrees
+	GPSAltitude      int64     `json:"gps_altitude"`
+	PressureAltitude int64     `json:"pressure_altitude"`
+}
+
+// trackData is everything needed to analyse a track without fetching it again
+// It is kept apart from the tracks so listing them doesn't load every fix
+type trackData struct {
+	UniqueID string
+	IGC      []byte // The original file, gzip compressed
+	Fixes    []fix
+}
+
+// Returns the original IGC file
+func (d trackData) rawIGC() ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(d.IGC))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// Compresses the IGC file, they are plain text and shrink to about a third
+func compressIGC(content []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(content); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Converts the points of the parsed track to fixes
+// The B-records only have the time of day, the date comes from the header.
+// A flight going past midnight UTC gets the next day for the fixes after it
+func trackFixes(track igc.Track) []fix {
+	date := time.Date(track.Date.Year(), track.Date.Month(), track.Date.Day(), 0, 0, 0, 0, time.UTC)
+
+	fixes := make([]fix, 0, len(track.Points))
+	var previous time.Duration
+	for _, point := range track.Points {
+		sinceMidnight := time.Duration(point.Time.Hour())*time.Hour + time.Duration(point.Time.Minute())*time.Minute +
+			time.Duration(point.Time.Second())*time.Second + time.Duration(point.Time.Nanosecond())
+		if sinceMidnight < previous {
+			date = date.AddDate(0, 0, 1)
+		}
+		previous = sinceMidnight
+
+		fixes = append(fixes, fix{
+			Time:             date.Add(sinceMidnight),
+			Lat:              point.Lat.Degrees(),
+			Lon:              point.Lng.Degrees(),
+			GPSAltitude:      point.GNSSAltitude,
+			PressureAltitude: point.PressureAltitude,
+		})
+	}
+	return fixes
+}
+
+// Builds the data kept for the track from the original file and its parsed content
+func newTrackData(id string, content []byte, track igc.Track) (trackData, error) {
+	compressed, err := compressIGC(content)
+	if err != nil {
+		return trackData{}, err
+	}
+	return trackData{UniqueID: id, IGC: compressed, Fixes: trackFixes(track)}, nil
+}
+
+// Returns the data of the track with the id of the URL
+// It answers the request with the error and returns false when there is none
+func requestedTrackData(w http.ResponseWriter, r *http.Request) (trackData, bool) {
+	id := mux.Vars(r)["id"]
+	if !regexp.MustCompile(`^[0-9]+$`).MatchString(id) {
+		http.Error(w, "400 - Bad Request", http.StatusBadRequest)
+		return trackData{}, false
+	}
+
+	data, found, err := tracksDB.TrackData(r.Context(), id)
+	if err != nil {
+		http.Error(w, "500 - Could not read the track", http.StatusInternalServerError)
+		return trackData{}, false
+	}
+	if !found {
+		http.Error(w, "404 - The track with that id doesn't exist, or its fixes are not stored yet", http.StatusNotFound)
+		return trackData{}, false
+	}
+	return data, true
+}
+
+// backfillReport is the response of the backfill, listing what happened to every track without data
+type backfillReport struct {
+	Backfilled []string          `json:"backfilled"`
+	Analysed   []string          `json:"analysed"` // The file was already stored, the values derived from it were computed again
+	Skipped    []string          `json:"skipped"`  // Uploaded tracks have no URL to fetch them from
+	Failed     map[string]string `json:"failed"`   // The error for every track that could not be fetched or parsed
+}
+
+// Fetches again the IGC file of every track stored before the files were kept, and stores its data
+// The tracks analysed by an older version of the service are analysed again from their stored fixes
+func backfillTrackData(ctx context.Context) (backfillReport, error) {
+	report := backfillReport{Backfilled: []string{}, Analysed: []string{}, Skipped: []string{}, Failed: map[string]string{}}
+
+	allTracks, err := tracksDB.AllTracks(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	for _, track := range allTracks {
+		data, found, err := tracksDB.TrackData(ctx, track.UniqueID)
+		if err != nil {
+			return report, err
+		}
+		if found {
+			// The file is there, only the values derived from it might be outdated
+			if track.AnalysisVersion < trackAnalysisVersion {
+				content, err := data.rawIGC()
+				if err != nil {
+					return report, err
+				}
+				parsed, err := igc.Parse(string(content))
+				if err != nil {
+					report.Failed[track.UniqueID] = igcParseError{err}.Error()
+					continue
+				}
+				track.setFileValues(parsed, validateIGC(content))
+				analyseTrack(&track, data.Fixes)
+				if err := tracksDB.UpdateTrack(ctx, track); err != nil {
+					return report, err
+				}
+				report.Analysed = append(report.Analysed, track.UniqueID)
+			}
+			continue
+		}
+		if track.URL == "" {
+			report.Skipped = append(report.Skipped, track.UniqueID)
+			continue
+		}
+
+		content, err := fetchIGC(ctx, track.URL)
+		if err != nil {
+			report.Failed[track.UniqueID] = err.Error()
+			continue
+		}
+		parsed, err := igc.Parse(string(content))
+		if err != nil {
+			report.Failed[track.UniqueID] = igcParseError{err}.Error()
+			continue
+		}
+
+		data, err = newTrackData(track.UniqueID, content, parsed)
+		if err != nil {
+			return report, err
+		}
+		if err := tracksDB.SaveTrackData(ctx, data); err != nil {
+			return report, err
+		}
+
+		// The tracks stored before the hashes existed can now be found as duplicates too
+		if track.ContentHash == "" {
+			track.ContentHash = contentHash(content)
+		}
+		track.setFileValues(parsed, validateIGC(content))
+		analyseTrack(&track, data.Fixes)
+		if err := tracksDB.UpdateTrack(ctx, track); err != nil {
+			return report, err
+		}
+
+		report.Backfilled = append(report.Backfilled, track.UniqueID)
+	}
+
+	return report, nil
+}
+
+// Handles path: POST /admin/api/tracks/backfill
+// Stores the IGC file and the fixes of the tracks registered before they were kept,
+// and computes again the values derived from the fixes when they are outdated
+func adminAPIBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	report, err := backfillTrackData(r.Context())
+	if err != nil {
+		http.Error(w, "500 - Could not backfill the tracks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
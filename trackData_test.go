@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	igc "github.com/marni/goigc"
+)
+
+func Test_trackFixes(t *testing.T) {
+	track, err := igc.Parse(string(readSampleIGC(t)))
+	if err != nil {
+		t.Fatalf("Error parsing the sample file, %s", err)
+	}
+
+	fixes := trackFixes(track)
+	if len(fixes) != len(track.Points) {
+		t.Fatalf("Expected %d fixes, got %d", len(track.Points), len(fixes))
+	}
+
+	first := fixes[0]
+	if first.Time.Year() != 2017 || first.Time.Month() != time.August || first.Time.Day() != 9 {
+		t.Errorf("Expected the fixes to have the date of the header, got %s", first.Time)
+	}
+	if first.Lat != track.Points[0].Lat.Degrees() || first.GPSAltitude != track.Points[0].GNSSAltitude {
+		t.Errorf("Unexpected first fix, %+v", first)
+	}
+
+	for i := 1; i < len(fixes); i++ {
+		if fixes[i].Time.Before(fixes[i-1].Time) {
+			t.Fatalf("Fix %d is before the previous one", i)
+		}
+	}
+}
+
+func Test_trackFixes_Midnight(t *testing.T) {
+	track := igc.NewTrack()
+	track.Date = time.Date(2018, 10, 31, 0, 0, 0, 0, time.UTC)
+	track.Points = []igc.Point{
+		{Time: time.Date(0, 1, 1, 23, 59, 50, 0, time.UTC)},
+		{Time: time.Date(0, 1, 1, 0, 0, 10, 0, time.UTC)},
+	}
+
+	fixes := trackFixes(track)
+	if fixes[1].Time.Sub(fixes[0].Time) != 20*time.Second {
+		t.Errorf("Expected the second fix on the next day, got %s and %s", fixes[0].Time, fixes[1].Time)
+	}
+}
+
+func Test_trackData_rawIGC(t *testing.T) {
+	content := readSampleIGC(t)
+
+	compressed, err := compressIGC(content)
+	if err != nil {
+		t.Fatalf("Error compressing, %s", err)
+	}
+	if len(compressed) >= len(content) {
+		t.Errorf("Expected the file to shrink, %d >= %d", len(compressed), len(content))
+	}
+
+	raw, err := trackData{IGC: compressed}.rawIGC()
+	if err != nil || !bytes.Equal(raw, content) {
+		t.Errorf("Expected the original file back, got %d bytes, %v", len(raw), err)
+	}
+}
+
+func Test_backfillTrackData(t *testing.T) {
+	useMemoryTracks(t)
+	ctx := context.Background()
+	content := readSampleIGC(t)
+	useFetcher(t, testFetchConfig(), config.Upload.MaxSize)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flight.igc" {
+			w.Write(content)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	// Tracks stored before the files were kept
+	tracksDB.InsertTrack(ctx, tracks{UniqueID: "1", URL: server.URL + "/flight.igc"})
+	tracksDB.InsertTrack(ctx, tracks{UniqueID: "2", URL: server.URL + "/gone.igc"})
+	tracksDB.InsertTrack(ctx, tracks{UniqueID: "3"})
+
+	report, err := backfillTrackData(ctx)
+	if err != nil {
+		t.Fatalf("Error backfilling, %s", err)
+	}
+	if len(report.Backfilled) != 1 || report.Backfilled[0] != "1" || report.Failed["2"] == "" ||
+		len(report.Skipped) != 1 || report.Skipped[0] != "3" {
+		t.Errorf("Unexpected report, %+v", report)
+	}
+
+	data, found, _ := tracksDB.TrackData(ctx, "1")
+	if !found || len(data.Fixes) == 0 {
+		t.Errorf("Expected the fixes of track 1 to be stored")
+	}
+	track, _, _ := tracksDB.TrackByID(ctx, "1")
+	if track.ContentHash != contentHash(content) {
+		t.Errorf("Expected the hash of track 1 to be set, got %q", track.ContentHash)
+	}
+
+	// Running it again has nothing left to do
+	report, _ = backfillTrackData(ctx)
+	if len(report.Backfilled) != 0 {
+		t.Errorf("Expected nothing to backfill, got %v", report.Backfilled)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// *** TRACK LISTING *** //
+
+// trackFilter selects the tracks of GET /api/track and of the listings built on it, the zero value selects every track
+type trackFilter struct {
+	Pilot     string // The text comparisons ignore the case
+	Glider    string
+	Class     string // Competition class
+	Status    string // Validation status
+	After     time.Time
+	Before    time.Time // The takeoff is between After and Before, the tracks without flight don't match
+	MinLength float64   // Km
+}
+
+// Reads the filter from the query, the message is the reason of the 400 when a value is wrong
+// The dates are days (2017-08-09), or times in the RFC 3339 format; a day in before includes the whole day
+func parseTrackFilter(values url.Values) (trackFilter, string) {
+	filter := trackFilter{
+		Pilot:  strings.TrimSpace(values.Get("pilot")),
+		Glider: strings.TrimSpace(values.Get("glider")),
+		Class:  strings.TrimSpace(values.Get("class")),
+		Status: strings.TrimSpace(values.Get("status")),
+	}
+	if filter.Status != "" && !hasValidationStatus(validationStatuses, filter.Status) {
+		return filter, "the status must be one of " + strings.Join(validationStatuses, ", ")
+	}
+
+	for name, value := range map[string]*time.Time{"after": &filter.After, "before": &filter.Before} {
+		param := values.Get(name)
+		if param == "" {
+			continue
+		}
+		if day, err := time.Parse("2006-01-02", param); err == nil {
+			if name == "before" {
+				day = day.Add(24*time.Hour - time.Nanosecond)
+			}
+			*value = day
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, param)
+		if err != nil {
+			return filter, "the " + name + " date must be a day like 2017-08-09, or a time in the RFC 3339 format"
+		}
+		*value = t
+	}
+
+	if param := values.Get("min_length"); param != "" {
+		length, err := strconv.ParseFloat(param, 64)
+		if err != nil || length < 0 {
+			return filter, "the min_length must be a number of km"
+		}
+		filter.MinLength = length
+	}
+	return filter, ""
+}
+
+// Returns true when the track passes every condition of the filter that is set
+func (filter trackFilter) matches(track tracks) bool {
+	sameText := func(want, value string) bool {
+		return want == "" || strings.EqualFold(want, strings.TrimSpace(value))
+	}
+	if !sameText(filter.Pilot, track.Pilot) || !sameText(filter.Glider, track.Glider) ||
+		!sameText(filter.Class, track.Header.CompetitionClass) || !sameText(filter.Status, track.ValidationStatus) {
+		return false
+	}
+	if !filter.After.IsZero() && (track.TakeoffTime.IsZero() || track.TakeoffTime.Before(filter.After)) {
+		return false
+	}
+	if !filter.Before.IsZero() && (track.TakeoffTime.IsZero() || track.TakeoffTime.After(filter.Before)) {
+		return false
+	}
+	return track.TrackLength >= filter.MinLength
+}
+
+// Returns the tracks listed by GET /api/track, in the order they were added: the ones matching the filter,
+// without the tracks whose status is in config.Validation.Hide
+func listTracks(ctx context.Context, filter trackFilter) ([]tracks, error) {
+	allTracks, err := tracksDB.AllTracks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listed := []tracks{}
+	for _, track := range allTracks {
+		if filter.matches(track) && !hasValidationStatus(config.Validation.Hide, track.ValidationStatus) {
+			listed = append(listed, track)
+		}
+	}
+	return listed, nil
+}
+
+// Returns the IDs listed by GET /api/track, see listTracks
+func listedTrackIDs(ctx context.Context, filter trackFilter) ([]string, error) {
+	// Without conditions only the IDs are needed
+	if filter == (trackFilter{}) && len(config.Validation.Hide) == 0 {
+		return tracksDB.TrackIDs(ctx)
+	}
+
+	listed, err := listTracks(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(listed))
+	for _, track := range listed {
+		ids = append(ids, track.UniqueID)
+	}
+	return ids, nil
+}
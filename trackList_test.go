@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_parseTrackFilter(t *testing.T) {
+	filter, problem := parseTrackFilter(url.Values{"pilot": {" Jane "}, "after": {"2017-08-09"}, "before": {"2017-08-09"}, "min_length": {"12.5"}})
+	if problem != "" {
+		t.Fatalf("Unexpected problem, %s", problem)
+	}
+	if filter.Pilot != "Jane" || filter.MinLength != 12.5 || !filter.After.Equal(time.Date(2017, 8, 9, 0, 0, 0, 0, time.UTC)) ||
+		!filter.Before.After(time.Date(2017, 8, 9, 23, 59, 59, 0, time.UTC)) {
+		t.Errorf("Unexpected filter, %+v", filter)
+	}
+
+	for _, values := range []url.Values{{"after": {"yesterday"}}, {"min_length": {"-1"}}, {"status": {"signed"}}, {"status": {"valid"}}} {
+		if _, problem := parseTrackFilter(values); problem == "" {
+			t.Errorf("Expected a problem for %v", values)
+		}
+	}
+}
+
+func Test_trackFilter_matches(t *testing.T) {
+	track := tracks{Pilot: "Jane Doe", Glider: "Ozone Enzo", TrackLength: 42, ValidationStatus: validationUnverified,
+		TakeoffTime: time.Date(2017, 8, 9, 12, 0, 0, 0, time.UTC), Header: trackHeader{CompetitionClass: "Open"}}
+
+	tests := []struct {
+		filter  trackFilter
+		matches bool
+	}{
+		{trackFilter{}, true},
+		{trackFilter{Pilot: "jane doe", Class: "open"}, true},
+		{trackFilter{Pilot: "Jane"}, false},
+		{trackFilter{Status: validationInvalid}, false},
+		{trackFilter{After: time.Date(2017, 8, 9, 0, 0, 0, 0, time.UTC), MinLength: 42}, true},
+		{trackFilter{Before: time.Date(2017, 8, 8, 0, 0, 0, 0, time.UTC)}, false},
+		{trackFilter{MinLength: 50}, false},
+	}
+	for _, test := range tests {
+		if matches := test.filter.matches(track); matches != test.matches {
+			t.Errorf("%+v: expected %v", test.filter, test.matches)
+		}
+	}
+
+	if (trackFilter{After: time.Date(2017, 8, 9, 0, 0, 0, 0, time.UTC)}).matches(tracks{}) {
+		t.Errorf("Expected a track without flight not to match a date")
+	}
+}
+
+func Test_handlerTrack_Filter(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+	postTrack(t, "application/octet-stream", encodeIGC(moveNorth(nil, 10*time.Minute, 30, 0), "HFPLTPILOT:Jane Doe"))
+
+	tests := map[string]string{
+		"":                                 "[1,2]",
+		"?pilot=jane%20doe":                "[2]",
+		"?after=2018-01-01":                "[2]",
+		"?before=2017-08-09":               "[1]",
+		"?min_length=100":                  "[]",
+		"?status=unsigned":                 "[2]",
+		"?pilot=Jane%20Doe&status=invalid": "[]",
+	}
+	for query, expected := range tests {
+		rec := httptest.NewRecorder()
+		handlerTrack(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track"+query, nil))
+		if rec.Code != http.StatusOK || rec.Body.String() != expected {
+			t.Errorf("%q: expected %s, got %d %s", query, expected, rec.Code, rec.Body.String())
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handlerTrack(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track?after=soon", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected StatusBadRequest %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// *** TRACK TABLE EXPORT *** //
+
+// trackTable is the track list as a table, one row per track, for the spreadsheets
+type trackTable struct {
+	Columns []string
+	Numeric []bool // Numeric[i] is true when the values of Columns[i] are numbers, written as such in the spreadsheets
+	Rows    [][]string
+}
+
+// tableExport is a file format the track table can be exported to
+type tableExport struct {
+	ContentType string
+	Extension   string
+	Write       func(w io.Writer, table trackTable) error
+}
+
+// The formats of GET /api/track/export, by the name used in ?format=
+var tableExports = map[string]tableExport{
+	"csv":  {ContentType: "text/csv; charset=utf-8", Extension: "csv", Write: writeTableCSV},
+	"xlsx": {ContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", Extension: "xlsx", Write: writeTableXLSX},
+}
+
+// Builds the table of the tracks: the values stored with the track, then the values derived from the fixes,
+// which are empty for the tracks that were not analysed yet (see the backfill)
+func newTrackTable(listed []tracks) trackTable {
+	table := trackTable{Rows: make([][]string, 0, len(listed))}
+	addColumn := func(name string, numeric bool) {
+		table.Columns = append(table.Columns, name)
+		table.Numeric = append(table.Numeric, numeric)
+	}
+	for _, name := range []string{"id", "pilot", "glider", "glider_id", "H_date"} {
+		addColumn(name, false)
+	}
+	addColumn("length", true)
+	addColumn("time_recorded", false)
+	addColumn("takeoff_time", false)
+	addColumn("landing_time", false)
+	addColumn("duration", true)
+	addColumn("airborne_time", true)
+	addColumn("score", true)
+	for _, field := range (tracks{}).statsFields() {
+		addColumn(field.Name, true)
+	}
+
+	for _, track := range listed {
+		row := []string{track.UniqueID, strings.TrimSpace(track.Pilot), strings.TrimSpace(track.Glider),
+			strings.TrimSpace(track.GliderID), track.Hdate, FloatToString(track.TrackLength), formatFlightTime(track.TimeRecorded)}
+		if track.AnalysisVersion == 0 {
+			row = append(row, make([]string, len(table.Columns)-len(row))...)
+		} else {
+			row = append(row, formatFlightTime(track.TakeoffTime), formatFlightTime(track.LandingTime),
+				formatSeconds(track.Duration), formatSeconds(track.AirborneTime), strconv.FormatFloat(track.Score.Score, 'f', 2, 64))
+			for _, field := range track.statsFields() {
+				row = append(row, field.Value)
+			}
+		}
+		table.Rows = append(table.Rows, row)
+	}
+	return table
+}
+
+// Handles path: GET /api/track/export?format=<format>
+// Returns the tracks listed by GET /api/track with the same filters as a table to download, in one of the tableExports formats
+func handlerTracksExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "501 - Method not implemented", http.StatusNotImplemented)
+		return
+	}
+
+	export, ok := tableExports[strings.ToLower(r.URL.Query().Get("format"))]
+	if !ok {
+		http.Error(w, "400 - Bad Request, the format must be one of csv, xlsx", http.StatusBadRequest)
+		return
+	}
+	filter, problem := parseTrackFilter(r.URL.Query())
+	if problem != "" {
+		http.Error(w, "400 - Bad Request, "+problem, http.StatusBadRequest)
+		return
+	}
+
+	listed, err := listTracks(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+		return
+	}
+
+	var file bytes.Buffer
+	if err := export.Write(&file, newTrackTable(listed)); err != nil {
+		http.Error(w, "500 - Could not export the tracks", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", export.ContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="tracks.`+export.Extension+`"`)
+	w.Write(file.Bytes())
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	igc "github.com/marni/goigc"
+)
+
+// *** TRACK REGISTRATION *** //
+
+// igcParseError is returned by registerTrack when the content is not a valid IGC file
+type igcParseError struct {
+	err error
+}
+
+func (e igcParseError) Error() string {
+	return "the file is not a valid IGC file: " + e.err.Error()
+}
+
+// Returns the hex encoded SHA-256 of the content, used to find files that were already uploaded
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// keyedMutex is a mutex per key, the locks are dropped once nobody holds or waits for them
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	users int // Holding or waiting for the lock
+}
+
+// Locks the key and returns the function that unlocks it
+func (k *keyedMutex) lock(key string) (unlock func()) {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.users++
+	k.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		k.mu.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// Serialises the registrations of the same file, by its hash, so two uploads can't both miss the duplicate check
+// The stores don't enforce unique hashes: the flights split from a file share it
+var registrations = keyedMutex{locks: map[string]*keyedLock{}}
+
+// Parses the IGC content and stores it as a new track
+// srcURL is the URL the content was fetched from, empty for uploaded files
+// When split is true and the file recorded several flights, every flight is stored as its own track
+// and the first one is returned, its Flights lists all of them
+// If the same file is already stored, that track is returned with duplicate set to true
+func registerTrack(ctx context.Context, content []byte, srcURL string, split bool) (trackFile tracks, duplicate bool, err error) {
+	hash := contentHash(content)
+	defer registrations.lock(hash)()
+
+	// Checking for duplicates by content, the same file might come from different URLs or uploads
+	trackInDB, duplicate, err := tracksDB.TrackByHash(ctx, hash)
+	if err != nil || duplicate {
+		return firstFlight(ctx, trackInDB), duplicate, err
+	}
+
+	track, err := igc.Parse(string(content))
+	if err != nil {
+		return tracks{}, false, igcParseError{err}
+	}
+
+	validation := validateIGC(content)
+	if hasValidationStatus(config.Validation.Reject, validation.Status) {
+		return tracks{}, false, igcValidationError{validation}
+	}
+
+	data, err := newTrackData("", content, track)
+	if err != nil {
+		return tracks{}, false, err
+	}
+	parts := [][]fix{data.Fixes}
+	if split {
+		parts = splitFlights(data.Fixes)
+	}
+
+	IDs := make([]string, len(parts))
+	for i := range parts {
+		ID, err := tracksDB.NextID(ctx, sequenceTracks)
+		if err != nil {
+			return tracks{}, false, err
+		}
+		IDs[i] = strconv.FormatInt(ID, 10)
+	}
+	var flights []string
+	if len(parts) > 1 {
+		flights = IDs
+	}
+
+	for i, fixes := range parts {
+		flight := tracks{
+			UniqueID:     IDs[i],
+			Pilot:        track.Pilot,
+			Glider:       track.GliderType,
+			GliderID:     track.GliderID,
+			Hdate:        track.Date.String(),
+			URL:          srcURL,
+			TimeRecorded: time.Now(),
+			ContentHash:  hash,
+			Flights:      flights,
+		}
+		flight.setFileValues(track, validation)
+		analyseTrack(&flight, fixes)
+
+		// Every flight keeps the whole file, with its own fixes
+		flightData := data
+		flightData.UniqueID = flight.UniqueID
+		flightData.Fixes = fixes
+
+		err = tracksDB.InsertTrack(ctx, flight)
+		if err == nil {
+			err = tracksDB.SaveTrackData(ctx, flightData)
+		}
+		if err != nil {
+			// The flights stored so far carry the hash, a retry would be answered as a duplicate of a partial file
+			removeTracks(IDs[:i+1])
+			return tracks{}, false, err
+		}
+
+		if i == 0 {
+			trackFile = flight
+		}
+	}
+
+	return trackFile, false, nil
+}
+
+// Removes the tracks stored from a file that could not be stored completely
+// The context of the request is not used, the removal must happen even when it was cancelled
+func removeTracks(IDs []string) {
+	for _, ID := range IDs {
+		if err := tracksDB.DeleteTrack(context.Background(), ID); err != nil {
+			log.Printf("Could not remove the track %s of a file that was not stored completely, %s", ID, err)
+		}
+	}
+}
+
+// Returns the first flight of the file the track was split from, or the track itself
+func firstFlight(ctx context.Context, track tracks) tracks {
+	if len(track.Flights) == 0 || track.Flights[0] == track.UniqueID {
+		return track
+	}
+	first, found, err := tracksDB.TrackByID(ctx, track.Flights[0])
+	if err != nil || !found {
+		return track
+	}
+	return first
+}
+
+// Returns the IDs of the tracks stored from the same file as the track, the track itself when it had a single flight
+func (track tracks) fileTrackIDs() []string {
+	if len(track.Flights) > 0 {
+		return track.Flights
+	}
+	return []string{track.UniqueID}
+}
+
+// Returns the number of the flight in the file it was split from, starting at 1, or 0 when the file had a single flight
+func (track tracks) flightNumber() int {
+	for i, ID := range track.Flights {
+		if ID == track.UniqueID {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// Returns false when the request asks to keep a file with several flights as a single track, with ?split=false
+func wantsSplit(r *http.Request) bool {
+	split := r.URL.Query().Get("split")
+	return split != "false" && split != "0"
+}
+
+// Downloads the IGC file at the URL and stores it as a new track, unless the same file is already stored
+// The URL is fetched again every time, the file behind it may have changed since it was registered
+func registerTrackURL(ctx context.Context, url string, split bool) (tracks, bool, error) {
+	content, err := fetchIGC(ctx, url)
+	if err != nil {
+		return tracks{}, false, err
+	}
+
+	return registerTrack(ctx, content, url, split)
+}
+
+// Writes the response for POST /api/track, and triggers the webhooks when a track was added
+func respondRegisteredTrack(w http.ResponseWriter, track tracks, duplicate bool, err error) {
+	switch err := err.(type) {
+	case igcParseError:
+		http.Error(w, "400 - Bad Request, "+err.Error(), http.StatusBadRequest)
+		return
+	case igcValidationError:
+		http.Error(w, "422 - Unprocessable Entity, "+err.Error(), http.StatusUnprocessableEntity)
+		return
+	case *fetchError:
+		// Telling the client why the URL was refused
+		err.respond(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, "500 - Could not store the track", http.StatusInternalServerError)
+		return
+	}
+
+	if duplicate {
+		// If there is another file in igcFilesDB with that content return and tell the user that that IGC FILE is already in the database
+		http.Error(w, "409 Conflict - The Igc File you entered is already in our database!", http.StatusConflict)
+		fmt.Fprintln(w, "\nThe file you entered has the following ID: ", track.UniqueID)
+		if len(track.Flights) > 0 {
+			fmt.Fprintln(w, "It was split into the flights: ", strings.Join(track.Flights, ", "))
+		}
+		return
+	}
+
+	// Encoding the ID of the track that was just added to DB, and of the other flights when the file was split
+	fmt.Fprint(w, "{\n\"id\":\""+track.UniqueID+"\"")
+	if len(track.Flights) > 0 {
+		fmt.Fprint(w, ",\n\"flights\":[\""+strings.Join(track.Flights, "\",\"")+"\"]")
+	}
+	fmt.Fprint(w, "\n}")
+
+	triggerWhenTrackIsAdded(track.fileTrackIDs())
+}
+
+// Handles POST /api/track when the IGC file itself is sent instead of a URL
+// The file can be the raw request body (application/octet-stream or text/plain),
+// or the "file" field of a multipart/form-data request
+func handlerTrackUpload(w http.ResponseWriter, r *http.Request, mediaType string) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, config.Upload.MaxSize)
+
+	var content []byte
+	var err error
+
+	if mediaType == "multipart/form-data" {
+		content, err = multipartIGC(r)
+	} else {
+		content, err = ioutil.ReadAll(r.Body)
+	}
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("413 - Request Entity Too Large, the file is bigger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, "400 - Bad Request, could not read the uploaded file: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(content) == 0 {
+		http.Error(w, "400 - Bad Request, the uploaded file is empty", http.StatusBadRequest)
+		return
+	}
+
+	split := wantsSplit(r)
+	if wantsAsync(r) {
+		respondQueuedJob(w, "", func(ctx context.Context) (tracks, bool, error) {
+			return registerTrack(ctx, content, "", split)
+		})
+		return
+	}
+
+	track, duplicate, err := registerTrack(r.Context(), content, "", split)
+	respondRegisteredTrack(w, track, duplicate, err)
+}
+
+// Reads the "file" part of a multipart upload, without buffering the other parts
+func multipartIGC(r *http.Request) ([]byte, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("the form has no \"file\" field")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			defer part.Close()
+			return ioutil.ReadAll(part)
+		}
+		part.Close()
+	}
+}
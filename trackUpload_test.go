@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const sampleIGC = "testdata/optimize-short-flight-1.igc"
+
+// Replaces the track store with an empty one for the duration of the test
+func useMemoryTracks(t *testing.T) {
+	previous := tracksDB
+	tracksDB = newMemoryStore()
+	t.Cleanup(func() { tracksDB = previous })
+}
+
+func readSampleIGC(t *testing.T) []byte {
+	content, err := ioutil.ReadFile(sampleIGC)
+	if err != nil {
+		t.Fatalf("Error reading the sample file, %s", err)
+	}
+	return content
+}
+
+// Posts the body to handlerTrack and returns the status code and the response body
+func postTrack(t *testing.T, contentType string, body []byte) (int, string) {
+	ts := httptest.NewServer(http.HandlerFunc(handlerTrack))
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL, contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error making the POST request, %s", err)
+	}
+	defer resp.Body.Close()
+
+	res, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(res)
+}
+
+func Test_handlerTrack_UploadRaw(t *testing.T) {
+	useMemoryTracks(t)
+	content := readSampleIGC(t)
+
+	status, body := postTrack(t, "application/octet-stream", content)
+	if status != http.StatusOK || !strings.Contains(body, `"id":"1"`) {
+		t.Fatalf("Expected the track to be added with id 1, got %d %s", status, body)
+	}
+
+	track, found, err := tracksDB.TrackByID(context.Background(), "1")
+	if err != nil || !found {
+		t.Fatalf("Expected track 1 to be stored, got %v %v", found, err)
+	}
+	if track.Glider != "DG 500" || track.URL != "" || track.ContentHash != contentHash(content) {
+		t.Errorf("Unexpected track stored, %+v", track)
+	}
+
+	data, found, _ := tracksDB.TrackData(context.Background(), "1")
+	raw, _ := data.rawIGC()
+	if !found || !bytes.Equal(raw, content) || len(data.Fixes) == 0 {
+		t.Errorf("Expected the IGC file and the fixes of track 1 to be stored")
+	}
+
+	// The same file sent again, as text, is a duplicate
+	status, body = postTrack(t, "text/plain; charset=utf-8", content)
+	if status != http.StatusConflict || !strings.Contains(body, "1") {
+		t.Errorf("Expected StatusConflict %d with the ID of the track, got %d %s", http.StatusConflict, status, body)
+	}
+}
+
+func Test_handlerTrack_UploadConcurrent(t *testing.T) {
+	useMemoryTracks(t)
+	content := readSampleIGC(t)
+	ts := httptest.NewServer(http.HandlerFunc(handlerTrack))
+	defer ts.Close()
+
+	// The same file uploaded at once, eg. by the batch ingestion and the background jobs
+	const uploads = 8
+	statuses := make(chan int, uploads)
+	var wg sync.WaitGroup
+	for i := 0; i < uploads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(ts.URL, "application/octet-stream", bytes.NewReader(content))
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusOK] != 1 || counts[http.StatusConflict] != uploads-1 {
+		t.Errorf("Expected one track added and %d duplicates, got %v", uploads-1, counts)
+	}
+	if count, _ := tracksDB.CountTracks(context.Background()); count != 1 {
+		t.Errorf("Expected exactly one track stored, got %d", count)
+	}
+	if len(registrations.locks) != 0 {
+		t.Errorf("Expected the locks to be dropped once released, got %d", len(registrations.locks))
+	}
+}
+
+func Test_handlerTrack_UploadMultipart(t *testing.T) {
+	useMemoryTracks(t)
+
+	form := &bytes.Buffer{}
+	writer := multipart.NewWriter(form)
+	writer.WriteField("comment", "fields before the file are skipped")
+	part, _ := writer.CreateFormFile("file", "flight.igc")
+	part.Write(readSampleIGC(t))
+	writer.Close()
+
+	status, body := postTrack(t, writer.FormDataContentType(), form.Bytes())
+	if status != http.StatusOK || !strings.Contains(body, `"id":"1"`) {
+		t.Fatalf("Expected the track to be added with id 1, got %d %s", status, body)
+	}
+}
+
+func Test_handlerTrack_UploadInvalid(t *testing.T) {
+	useMemoryTracks(t)
+
+	testCases := map[string][]byte{
+		"empty file":  {},
+		"not an IGC":  []byte("just some text"),
+		"no IGC file": []byte("--boundary--\r\n"),
+	}
+
+	for name, content := range testCases {
+		contentType := "application/octet-stream"
+		if name == "no IGC file" {
+			contentType = "multipart/form-data; boundary=boundary"
+		}
+
+		status, body := postTrack(t, contentType, content)
+		if status != http.StatusBadRequest {
+			t.Errorf("%s: expected StatusBadRequest %d, got %d %s", name, http.StatusBadRequest, status, body)
+		}
+	}
+
+	if count, _ := tracksDB.CountTracks(context.Background()); count != 0 {
+		t.Errorf("Expected no tracks to be stored, got %d", count)
+	}
+}
+
+func Test_handlerTrack_UploadTooLarge(t *testing.T) {
+	useMemoryTracks(t)
+
+	previous := config.Upload.MaxSize
+	config.Upload.MaxSize = 100
+	defer func() { config.Upload.MaxSize = previous }()
+
+	status, body := postTrack(t, "application/octet-stream", readSampleIGC(t))
+	if status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected StatusRequestEntityTooLarge %d, got %d %s", http.StatusRequestEntityTooLarge, status, body)
+	}
+
+	form := &bytes.Buffer{}
+	writer := multipart.NewWriter(form)
+	part, _ := writer.CreateFormFile("file", "flight.igc")
+	part.Write(readSampleIGC(t))
+	writer.Close()
+	status, body = postTrack(t, writer.FormDataContentType(), form.Bytes())
+	if status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected StatusRequestEntityTooLarge %d for the form, got %d %s", http.StatusRequestEntityTooLarge, status, body)
+	}
+}
+
+func Test_registerTrackURL_Changed(t *testing.T) {
+	useMemoryTracks(t)
+	useFetcher(t, testFetchConfig(), config.Upload.MaxSize)
+
+	// The file behind the URL is replaced after it was registered
+	content := readSampleIGC(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(content) }))
+	defer server.Close()
+	url := server.URL + "/flight.igc"
+
+	if _, duplicate, err := registerTrackURL(context.Background(), url, true); err != nil || duplicate {
+		t.Fatalf("Expected the track to be added, got %v %v", duplicate, err)
+	}
+	if track, duplicate, _ := registerTrackURL(context.Background(), url, true); !duplicate || track.UniqueID != "1" {
+		t.Errorf("Expected the same file to be a duplicate of track 1, got %v %s", duplicate, track.UniqueID)
+	}
+
+	content = encodeIGC(moveNorth(nil, 10*time.Minute, 30, 0))
+	track, duplicate, err := registerTrackURL(context.Background(), url, true)
+	if err != nil || duplicate || track.UniqueID != "2" {
+		t.Errorf("Expected the new file to be added as track 2, got %v %s %v", duplicate, track.UniqueID, err)
+	}
+}
+
+func Test_handlerTrack_UploadSplit(t *testing.T) {
+	useMemoryTracks(t)
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1)
+	fixes = moveNorth(fixes, 20*time.Minute, 0, 0)
+	fixes = moveNorth(fixes, 10*time.Minute, 30, -1)
+	content := encodeIGC(fixes)
+
+	status, body := postTrack(t, "application/octet-stream", content)
+	if status != http.StatusOK || !strings.Contains(body, `"id":"1"`) || !strings.Contains(body, `"flights":["1","2"]`) {
+		t.Fatalf("Expected the file to be split into tracks 1 and 2, got %d %s", status, body)
+	}
+
+	for i, id := range []string{"1", "2"} {
+		track, found, err := tracksDB.TrackByID(context.Background(), id)
+		if err != nil || !found {
+			t.Fatalf("Expected track %s to be stored, got %v %v", id, found, err)
+		}
+		if track.flightNumber() != i+1 || track.AirborneTime != 10*time.Minute {
+			t.Errorf("Expected track %s to be the flight %d of 10 minutes, got %d %s", id, i+1, track.flightNumber(), track.AirborneTime)
+		}
+		data, _, _ := tracksDB.TrackData(context.Background(), id)
+		raw, _ := data.rawIGC()
+		if !bytes.Equal(raw, content) || len(data.Fixes) >= len(fixes) {
+			t.Errorf("Expected track %s to keep the file with the fixes of its flight only, got %d fixes", id, len(data.Fixes))
+		}
+	}
+
+	// The duplicate answers with the first flight
+	status, body = postTrack(t, "application/octet-stream", content)
+	if status != http.StatusConflict || !strings.Contains(body, "ID:  1") {
+		t.Errorf("Expected StatusConflict %d with the first flight, got %d %s", http.StatusConflict, status, body)
+	}
+}
+
+// failingDataStore fails to save the data of the track with the given ID
+type failingDataStore struct {
+	*memoryStore
+	failID string
+}
+
+func (s failingDataStore) SaveTrackData(ctx context.Context, data trackData) error {
+	if data.UniqueID == s.failID {
+		return errors.New("disk full")
+	}
+	return s.memoryStore.SaveTrackData(ctx, data)
+}
+
+func Test_handlerTrack_UploadSplitFailure(t *testing.T) {
+	useMemoryTracks(t)
+	store := newMemoryStore()
+	tracksDB = failingDataStore{store, "2"}
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1)
+	fixes = moveNorth(fixes, 20*time.Minute, 0, 0)
+	fixes = moveNorth(fixes, 10*time.Minute, 30, -1)
+	content := encodeIGC(fixes)
+
+	if status, body := postTrack(t, "application/octet-stream", content); status != http.StatusInternalServerError {
+		t.Fatalf("Expected StatusInternalServerError %d, got %d %s", http.StatusInternalServerError, status, body)
+	}
+	// Nothing of the file is left, so it can be uploaded again
+	if count, _ := store.CountTracks(context.Background()); count != 0 {
+		t.Errorf("Expected the flights already stored to be removed, got %d tracks", count)
+	}
+	if _, found, _ := store.TrackData(context.Background(), "1"); found {
+		t.Error("Expected the data of the first flight to be removed")
+	}
+
+	tracksDB = store
+	if status, body := postTrack(t, "application/octet-stream", content); status != http.StatusOK || !strings.Contains(body, `"flights":["3","4"]`) {
+		t.Errorf("Expected the file to be stored on the retry, got %d %s", status, body)
+	}
+}
+
+func Test_handlerTrack_URLSplitDuplicate(t *testing.T) {
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1)
+	fixes = moveNorth(fixes, 20*time.Minute, 0, 0)
+	fixes = moveNorth(fixes, 10*time.Minute, 30, -1)
+	content := encodeIGC(fixes)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(content) }))
+	defer server.Close()
+	useFetcher(t, testFetchConfig(), config.Upload.MaxSize)
+	body := []byte(`{"url":"` + server.URL + `/flights.igc"}`)
+
+	bolt, err := newBoltStore(filepath.Join(t.TempDir(), "tracks.db"))
+	if err != nil {
+		t.Fatalf("Error opening the bolt store, %s", err)
+	}
+	defer bolt.Close(context.Background())
+
+	// The stores index the flights sharing the file differently, the duplicate is always the first flight
+	for name, store := range map[string]TrackStore{"memory": newMemoryStore(), "bolt": bolt} {
+		useMemoryTracks(t)
+		tracksDB = store
+
+		if status, res := postTrack(t, "application/json", body); status != http.StatusOK || !strings.Contains(res, `"flights":["1","2"]`) {
+			t.Fatalf("%s: expected the file to be split into tracks 1 and 2, got %d %s", name, status, res)
+		}
+		status, res := postTrack(t, "application/json", body)
+		if status != http.StatusConflict || !strings.Contains(res, "ID:  1\n") {
+			t.Errorf("%s: expected StatusConflict %d with the first flight, got %d %s", name, http.StatusConflict, status, res)
+		}
+	}
+}
+
+func Test_handlerTrack_UploadNoSplit(t *testing.T) {
+	useMemoryTracks(t)
+	fixes := moveNorth(nil, 10*time.Minute, 30, -1)
+	fixes = moveNorth(fixes, 20*time.Minute, 0, 0)
+	fixes = moveNorth(fixes, 10*time.Minute, 30, -1)
+
+	ts := httptest.NewServer(http.HandlerFunc(handlerTrack))
+	defer ts.Close()
+	resp, err := http.Post(ts.URL+"?split=false", "application/octet-stream", bytes.NewReader(encodeIGC(fixes)))
+	if err != nil {
+		t.Fatalf("Error making the POST request, %s", err)
+	}
+	defer resp.Body.Close()
+	res, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || strings.Contains(string(res), "flights") {
+		t.Fatalf("Expected a single track, got %d %s", resp.StatusCode, res)
+	}
+
+	track, _, _ := tracksDB.TrackByID(context.Background(), "1")
+	if len(track.Flights) != 0 || track.Duration != 40*time.Minute {
+		t.Errorf("Expected the whole file as one track of 40 minutes, got %v %s", track.Flights, track.Duration)
+	}
+}
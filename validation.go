@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// *** SECURITY RECORD VALIDATION *** //
+
+// Validation statuses of the IGC files, stored on the tracks
+const (
+	validationUnsigned   = "unsigned"   // The file has no G-record
+	validationUnverified = "unverified" // Signed and well formed, the signature itself is not checked
+	validationInvalid    = "invalid"    // The file was changed around the signature
+)
+
+var validationStatuses = []string{validationUnsigned, validationUnverified, validationInvalid}
+
+// Three letter codes of the manufacturers whose recorders are approved by the IGC,
+// the other recorders (eg. the phone apps) use codes starting with X
+var approvedManufacturers = map[string]bool{
+	"ACT": true, // Aircotec
+	"CAM": true, // Cambridge Aero Instruments
+	"CNI": true, // ClearNav Instruments
+	"DSX": true, // Data Swan
+	"EWA": true, // EW Avionics
+	"FIL": true, // Filser
+	"FLA": true, // FLARM
+	"FLY": true, // Flytech
+	"GCS": true, // Garrecht
+	"IMI": true, // IMI Gliding Equipment
+	"LGS": true, // Logstream
+	"LXN": true, // LX Navigation
+	"LXV": true, // LXNAV
+	"NAV": true, // Naviter
+	"NKL": true, // Nielsen Kellerman
+	"NTE": true, // New Technologies
+	"PES": true, // Peschges
+	"PFE": true, // PressFinish Electronics
+	"PRT": true, // Print Technik
+	"SCH": true, // Scheffel
+	"SDI": true, // Streamline Data Instruments
+	"TRI": true, // Triadis Engineering
+	"WES": true, // Westerboer
+	"ZAN": true, // Zander
+}
+
+// fileValidation is the outcome of the checks of an IGC file
+type fileValidation struct {
+	Status       string
+	Manufacturer string // Three letter code from the A-record
+	Approved     bool   // The A-record names a recorder approved by the IGC
+	Problem      string // Why the file is invalid
+}
+
+// Returns true when the status is one of the list, eg. config.Validation.Reject
+func hasValidationStatus(statuses []string, status string) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true when the line only has the letters and digits the signatures are written with
+func isSignatureLine(line string) bool {
+	for _, c := range line {
+		if !(c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z') {
+			return false
+		}
+	}
+	return len(line) > 0
+}
+
+// Checks the structure of the IGC file around its G-records, the signature itself is not checked:
+// the A-record must come first and the G-records last, anything added after the signature means the file was changed
+func validateIGC(content []byte) fileValidation {
+	validation := fileValidation{Status: validationUnsigned}
+
+	records := 0
+	signature := ""
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if records == 0 && signature == "" {
+			if line[0] != 'A' || len(line) < 4 {
+				return fileValidation{Status: validationInvalid, Problem: "the file doesn't start with the A-record"}
+			}
+			validation.Manufacturer = strings.ToUpper(line[1:4])
+			validation.Approved = approvedManufacturers[validation.Manufacturer]
+		}
+
+		if line[0] == 'G' {
+			if !isSignatureLine(line[1:]) {
+				validation.Status, validation.Problem = validationInvalid, "the G-record has unexpected characters"
+				return validation
+			}
+			signature += line[1:]
+			continue
+		}
+		if signature != "" {
+			validation.Status, validation.Problem = validationInvalid, fmt.Sprintf("the %c-record is after the G-record", line[0])
+			return validation
+		}
+		records++
+	}
+
+	if signature != "" {
+		validation.Status = validationUnverified
+	}
+	return validation
+}
+
+// igcValidationError is returned by registerTrack when the configuration refuses the files with that status
+type igcValidationError struct {
+	validation fileValidation
+}
+
+func (e igcValidationError) Error() string {
+	message := "the file is " + e.validation.Status
+	if e.validation.Problem != "" {
+		message += ", " + e.validation.Problem
+	}
+	return message + ", and such files are not accepted"
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Replaces the validation settings for the duration of the test
+func useValidationConfig(t *testing.T, cfg ValidationConfig) {
+	previous := config.Validation
+	config.Validation = cfg
+	t.Cleanup(func() { config.Validation = previous })
+}
+
+func Test_validateIGC(t *testing.T) {
+	sample := validateIGC(readSampleIGC(t))
+	if sample.Status != validationUnverified || sample.Manufacturer != "FLA" || !sample.Approved {
+		t.Errorf("Expected the sample to be signed by an approved FLARM, got %+v", sample)
+	}
+
+	unsigned := validateIGC(encodeIGC(moveNorth(nil, time.Minute, 30, 0)))
+	if unsigned.Status != validationUnsigned || unsigned.Manufacturer != "XXX" || unsigned.Approved {
+		t.Errorf("Expected an unsigned file of a recorder that is not approved, got %+v", unsigned)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		status  string
+	}{
+		{"no A-record", "HFDTE090817\r\nGABC\r\n", validationInvalid},
+		{"record after the signature", "AFLA5HH\r\nHFDTE090817\r\nGABC\r\nLXXXcomment\r\n", validationInvalid},
+		{"bad signature characters", "AFLA5HH\r\nHFDTE090817\r\nGAB-C\r\n", validationInvalid},
+		{"signature on several lines", "AFLA5HH\r\nHFDTE090817\r\nGAB\r\nGCD\r\n\r\n", validationUnverified},
+	}
+	for _, test := range tests {
+		if validation := validateIGC([]byte(test.content)); validation.Status != test.status {
+			t.Errorf("%s: expected %s, got %+v", test.name, test.status, validation)
+		}
+	}
+}
+
+func Test_handlerTrack_ValidationReject(t *testing.T) {
+	useMemoryTracks(t)
+	useValidationConfig(t, ValidationConfig{Reject: []string{validationUnsigned}})
+
+	status, body := postTrack(t, "application/octet-stream", encodeIGC(moveNorth(nil, time.Minute, 30, 0)))
+	if status != http.StatusUnprocessableEntity || !strings.Contains(body, "unsigned") {
+		t.Errorf("Expected StatusUnprocessableEntity %d for the unsigned file, got %d %s", http.StatusUnprocessableEntity, status, body)
+	}
+
+	status, body = postTrack(t, "application/octet-stream", readSampleIGC(t))
+	if status != http.StatusOK {
+		t.Fatalf("Expected the signed sample to be accepted, got %d %s", status, body)
+	}
+	track, _, _ := tracksDB.TrackByID(context.Background(), "1")
+	if track.ValidationStatus != validationUnverified || !track.IGCApproved {
+		t.Errorf("Expected the validation of the sample to be stored, got %q %v", track.ValidationStatus, track.IGCApproved)
+	}
+}
+
+func Test_handlerTrack_ValidationHide(t *testing.T) {
+	useMemoryTracks(t)
+	postTrack(t, "application/octet-stream", readSampleIGC(t))
+	postTrack(t, "application/octet-stream", encodeIGC(moveNorth(nil, time.Minute, 30, 0)))
+	useValidationConfig(t, ValidationConfig{Hide: []string{validationUnsigned}})
+
+	rec := httptest.NewRecorder()
+	handlerTrack(rec, httptest.NewRequest(http.MethodGet, "/paragliding/api/track", nil))
+	if body := rec.Body.String(); body != "[1]" {
+		t.Errorf("Expected only the signed track to be listed, got %s", body)
+	}
+}
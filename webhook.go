@@ -2,19 +2,18 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"fmt"
 	"log"
-	"math/rand"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/mongodb/mongo-go-driver/bson"
-	"github.com/mongodb/mongo-go-driver/mongo"
 )
 
 // *** WEBHOOK API *** //
@@ -57,57 +56,39 @@ func webhookNewTrack(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	conn := mongoConnect()
-	db := conn.Database("igcfiles")   // igcFiles Database
-	coll := db.Collection("webhooks") // webhooks Collection
-
 	// Check if Webhook exists
-	cursor, err := coll.Find(context.Background(),
-		bson.NewDocument(bson.EC.String("webhookurl", webhook.WebhookURL)))
+	webhookInDB, found, err := webhooksDB.WebhookByURL(r.Context(), webhook.WebhookURL)
 	if err != nil {
-		log.Fatal(err)
+		http.Error(w, "500 - Could not read the webhooks", http.StatusInternalServerError)
+		return
 	}
 
-	// 'Close' the cursor
-	defer cursor.Close(context.Background())
-
-	webhookInDB := Webhook{}
-
-	// Point the cursor at whatever is found
-	for cursor.Next(context.Background()) {
-		err = cursor.Decode(&webhookInDB)
-		if err != nil {
-			log.Fatal(err)
-		}
-
+	if found {
 		fmt.Fprintln(w, "The webhook you entered has been updated and has this ID: ", webhookInDB.WebhookID)
 
 		// If the webhook is already in the DB, then update the minTriggerValue because that one can be changed even after
 		// the webhook has been registered. But the ID doesn't change
-		_, err := coll.UpdateOne(context.Background(),
-			bson.NewDocument(
-				bson.EC.String("webhookurl", webhook.WebhookURL),
-			),
-			bson.NewDocument(
-				bson.EC.SubDocumentFromElements("$set", bson.EC.Int32("mintriggervalue", webhook.MinTriggerValue)),
-			),
-		)
+		err := webhooksDB.UpdateWebhookTrigger(r.Context(), webhook.WebhookURL, webhook.MinTriggerValue)
 		if err != nil {
-			log.Fatal(err)
+			log.Println("Error updating the webhook, ", err)
 		}
 
 		return
 	}
 
 	// Create an ID for the new webhook
-	uniqueID := rand.Intn(1000)
-	webhook.WebhookID = strconv.Itoa(uniqueID)
+	uniqueID, err := webhooksDB.NextID(r.Context(), sequenceWebhooks)
+	if err != nil {
+		http.Error(w, "500 - Could not create an ID for the webhook", http.StatusInternalServerError)
+		return
+	}
+	webhook.WebhookID = strconv.FormatInt(uniqueID, 10)
 
 	// Insert the webhook if this one isn't in the Database
-	_, err = coll.InsertOne(context.Background(), webhook)
-
+	err = webhooksDB.InsertWebhook(r.Context(), webhook)
 	if err != nil {
-		log.Fatal(err)
+		http.Error(w, "500 - Could not store the webhook", http.StatusInternalServerError)
+		return
 	}
 
 	// Encoding the ID of the track that was just added to DB
@@ -126,28 +107,13 @@ func webhookID(w http.ResponseWriter, r *http.Request) {
 
 		urlVars := mux.Vars(r)
 
-		client := mongoConnect()
-
-		collection := client.Database("igcfiles").Collection("webhooks")
-
-		cursor, err := collection.Find(context.Background(),
-			bson.NewDocument(bson.EC.String("webhookid", urlVars["webhook_id"])))
+		webhook, found, err := webhooksDB.WebhookByID(r.Context(), urlVars["webhook_id"])
 		if err != nil {
-			log.Fatal(err)
+			http.Error(w, "500 - Could not read the webhooks", http.StatusInternalServerError)
+			return
 		}
 
-		// 'Close' the cursor
-		defer cursor.Close(context.Background())
-
-		webhook := Webhook{}
-
-		// Point the cursor at whatever is found
-		for cursor.Next(context.Background()) {
-			err = cursor.Decode(&webhook)
-			if err != nil {
-				log.Fatal(err)
-			}
-
+		if found {
 			json.NewEncoder(w).Encode(webhook)
 
 			return
@@ -164,32 +130,22 @@ func webhookID(w http.ResponseWriter, r *http.Request) {
 
 		urlVars := mux.Vars(r)
 
-		client := mongoConnect()
-
-		collection := client.Database("igcfiles").Collection("webhooks")
-
-		cursor, err := collection.Find(context.Background(), bson.NewDocument(bson.EC.String("webhookid", urlVars["webhook_id"])))
+		webhook, found, err := webhooksDB.WebhookByID(r.Context(), urlVars["webhook_id"])
 		if err != nil {
-			log.Fatal(err)
+			http.Error(w, "500 - Could not read the webhooks", http.StatusInternalServerError)
+			return
 		}
 
-		// 'Close' the cursor
-		defer cursor.Close(context.Background())
-
-		webhook := Webhook{}
-
-		// Point the cursor at whatever is found
-		for cursor.Next(context.Background()) {
-			err = cursor.Decode(&webhook)
+		if found {
+			// Delete the webhook that was found
+			err = webhooksDB.DeleteWebhook(r.Context(), webhook.WebhookID)
 			if err != nil {
-				log.Fatal(err)
+				http.Error(w, "500 - Could not delete the webhook", http.StatusInternalServerError)
+				return
 			}
 
 			json.NewEncoder(w).Encode(webhook)
 
-			// Delete the webhook that was found
-			deleteWebhook(client, webhook.WebhookID)
-
 			return
 
 		}
@@ -209,26 +165,41 @@ func webhookID(w http.ResponseWriter, r *http.Request) {
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// This function is called whenever a Track is registered in DB
+// This function is called whenever Tracks are registered in DB, with the IDs of the new tracks
 // The frequency of this function to be triggered depends on the minTriggerValue, which
 // indicates the frequency of updates - after how many tracks the webhook should be called
-func triggerWhenTrackIsAdded() {
+// A batch of tracks triggers every webhook at most once, even if it adds more than minTriggerValue tracks
+func triggerWhenTrackIsAdded(newIDs []string) {
 
-	// Connect to DB
-	clientDB := mongoConnect()
+	ctx := context.Background()
 
-	resultWebhooks := getAllWebhooks(clientDB)
+	resultWebhooks, err := webhooksDB.AllWebhooks(ctx)
+	if err != nil {
+		log.Println("Error reading the webhooks, ", err)
+		return
+	}
 
-	for _, val := range resultWebhooks {
+	// The IDs of all tracks, in the order they were added
+	trackIDs, err := tracksDB.TrackIDs(ctx)
+	if err != nil {
+		log.Println("Error reading the tracks, ", err)
+		return
+	}
+
+	// Counting the number of track at the moment, and before the new ones were added
+	trackCount := int32(len(trackIDs))
+	countBefore := trackCount - int32(len(newIDs))
+	if countBefore < 0 {
+		countBefore = 0
+	}
 
-		// Counting the number of track at the moment
-		trackCount := int32(countAllTracks(clientDB))
+	for _, val := range resultWebhooks {
 
 		// Saving its minimal trigger value for later use
 		minTriggerValue := val.MinTriggerValue
 
-		// Check according to minTriggerValue when to trigger the webhook
-		if trackCount%minTriggerValue == 0 {
+		// Check according to minTriggerValue when to trigger the webhook: when the count went past a multiple of it
+		if minTriggerValue > 0 && trackCount/minTriggerValue > countBefore/minTriggerValue {
 
 			// Creating an instance of WebhookContent stuct
 			webhookInfo := &WebhookContent{}
@@ -240,35 +211,8 @@ func triggerWhenTrackIsAdded() {
 			// Saving the latest added timestamp of the entire collection
 			webhookInfo.TLatest = timestamps.latestTimestamp.String()
 
-			// Creating a slice where all the IDs of track in DB are going to be saved
-			WebhookInfoTrackIDs := make([]string, 0, 0)
-
-			collection := clientDB.Database("igcfiles").Collection("tracks")
-
-			// Find all the documents(tracks) in that collection
-			cursor, err := collection.Find(context.Background(), nil, nil)
-			if err != nil {
-				log.Fatal(err)
-			}
-
-			// 'Close' the cursor
-			defer cursor.Close(context.Background())
-
-			track := tracks{}
-
-			// Point the cursor at whatever is found
-			for cursor.Next(context.Background()) {
-				err = cursor.Decode(&track)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				// Append all the IDs of tracks in our DB to this slice
-				WebhookInfoTrackIDs = append(WebhookInfoTrackIDs, track.UniqueID)
-
-			}
-
-			webhookInfo.Tracks = WebhookInfoTrackIDs
+			// Only the tracks added since the webhook was triggered last time
+			webhookInfo.Tracks = trackIDs[countBefore/minTriggerValue*minTriggerValue:]
 
 			// Formating the processing time, time in ms of how long it took to process the request
 			webhookInfo.Processing = strconv.FormatFloat(float64(time.Since(processStart))/float64(time.Millisecond), 'f', 2, 64) + " ms"
@@ -280,76 +224,78 @@ func triggerWhenTrackIsAdded() {
 			content += " \n\t\"processing\" : \"" + webhookInfo.Processing + "\" \n}\n"
 			content += "```"
 
-			// Adding the values to URL
-			data := url.Values{}
-			data.Set("username", "TrackAdded")
-			data.Add("content", content)
-
-			u, _ := url.ParseRequestURI(val.WebhookURL)
-			urlStr := u.String()
+			deliverWebhook(val.WebhookURL, "TrackAdded", content)
 
-			client := &http.Client{}
+		}
 
-			// Creating a new POST request to the webhook URL and sending the specified data to be printed in Discord
-			r, err := http.NewRequest("POST", urlStr, strings.NewReader(data.Encode())) // URL-encoded payload
-			if err != nil {
-				fmt.Println("Error constructing the POST request, ", err)
-			}
+	}
 
-			// Specifying the request header parameters to send the data as JSON
-			r.Header.Add("Authorization", "auth_token=\"XXXXXXX\"")
-			r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-			r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
+}
 
-			resp, err := client.Do(r)
-			if err != nil {
-				fmt.Println("Error executing the POST request, ", err)
-			}
+// Webhook deliveries that are still running, main() waits for them before stopping
+var webhookDeliveries sync.WaitGroup
 
-			defer resp.Body.Close()
+// Sends the content to the webhook in the background, so the request that added the track doesn't wait for it
+func deliverWebhook(webhookURL string, username string, content string) {
+	webhookDeliveries.Add(1)
+	go func() {
+		defer webhookDeliveries.Done()
 
+		err := postToWebhook(webhookURL, username, content)
+		if err != nil {
+			fmt.Println("Error executing the POST request, ", err)
 		}
+	}()
+}
 
+// Waits for the pending webhook deliveries, or until ctx is done
+func waitForWebhooks(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		webhookDeliveries.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-
 }
 
-// Delete webhook with the ID specified in function parameters
-func deleteWebhook(client *mongo.Client, webhookID string) {
-	db := client.Database("igcfiles")
-	collection := db.Collection("webhooks")
+// Sends the content to the webhook URL, in the form that Discord expects
+func postToWebhook(webhookURL string, username string, content string) error {
 
-	// Delete the webhook
-	collection.DeleteOne(context.Background(), bson.NewDocument(bson.EC.String("webhookid", webhookID)))
-}
+	// Adding the values to URL
+	data := url.Values{}
+	data.Set("username", username)
+	data.Add("content", content)
 
-// Get all webhooks
-func getAllWebhooks(client *mongo.Client) []Webhook {
-	db := client.Database("igcfiles")
-	collection := db.Collection("webhooks")
+	u, err := url.ParseRequestURI(webhookURL)
+	if err != nil {
+		return err
+	}
 
-	var cursor mongo.Cursor
-	var err error
-	cursor, err = collection.Find(context.Background(), nil)
+	client := &http.Client{Timeout: config.Webhook.Timeout}
 
+	// Creating a new POST request to the webhook URL and sending the specified data to be printed in Discord
+	r, err := http.NewRequest("POST", u.String(), strings.NewReader(data.Encode())) // URL-encoded payload
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	defer cursor.Close(context.Background())
-
-	resWebhooks := []Webhook{}
-	resWebhook := Webhook{}
+	// Specifying the request header parameters to send the data as JSON
+	r.Header.Add("Authorization", "auth_token=\"XXXXXXX\"")
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
 
-	for cursor.Next(context.Background()) {
-		err := cursor.Decode(&resWebhook)
-		if err != nil {
-			log.Fatal(err)
-		}
-		resWebhooks = append(resWebhooks, resWebhook) // Append each resTrack to resTracks slice
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
 	}
 
-	return resWebhooks
+	return resp.Body.Close()
 }
 
 var latestTrackCounter = 1
@@ -357,16 +303,20 @@ var latestTrackCounter = 1
 //
 func clockTrigger(w http.ResponseWriter, r *http.Request) {
 
-	clientDB := mongoConnect()
-
-	currentTrackCount := int(countAllTracks(clientDB))
+	count, err := tracksDB.CountTracks(r.Context())
+	if err != nil {
+		http.Error(w, "500 - Could not count the tracks", http.StatusInternalServerError)
+		return
+	}
+	currentTrackCount := int(count)
 
 	if latestTrackCounter != currentTrackCount {
 
-		resultWebhooks := getAllWebhooks(clientDB)
-		allTracks := getAllTracks(clientDB)
-
-		allTracks = allTracks[latestTrackCounter-1:]
+		resultWebhooks, err := webhooksDB.AllWebhooks(r.Context())
+		if err != nil {
+			http.Error(w, "500 - Could not read the webhooks", http.StatusInternalServerError)
+			return
+		}
 
 		for _, val := range resultWebhooks {
 
@@ -378,32 +328,12 @@ func clockTrigger(w http.ResponseWriter, r *http.Request) {
 
 			webhookInfo.TLatest = timestamps.latestTimestamp.String()
 
-			WebhookInfoTrackIDs := make([]string, 0, 0)
-
-			collection := clientDB.Database("igcfiles").Collection("tracks")
-
-			cursor, err := collection.Find(context.Background(), nil, nil)
+			webhookInfo.Tracks, err = tracksDB.TrackIDs(r.Context())
 			if err != nil {
-				log.Fatal(err)
+				http.Error(w, "500 - Could not read the tracks", http.StatusInternalServerError)
+				return
 			}
 
-			// 'Close' the cursor
-			defer cursor.Close(context.Background())
-
-			track := tracks{}
-
-			// Point the cursor at whatever is found
-			for cursor.Next(context.Background()) {
-				err = cursor.Decode(&track)
-				if err != nil {
-					log.Fatal(err)
-				}
-				WebhookInfoTrackIDs = append(WebhookInfoTrackIDs, track.UniqueID)
-
-			}
-
-			webhookInfo.Tracks = WebhookInfoTrackIDs
-
 			webhookInfo.Processing = strconv.FormatFloat(float64(time.Since(processStart))/float64(time.Millisecond), 'f', 2, 64) + " ms"
 
 			content := "\n"
@@ -411,31 +341,11 @@ func clockTrigger(w http.ResponseWriter, r *http.Request) {
 			content += " \nNew tracks are: [ " + strings.Join(webhookInfo.Tracks, ", ") + " ] ."
 			content += " \nThe request took: " + webhookInfo.Processing + " time to process .\n"
 
-			data := url.Values{}
-			data.Set("username", "tracks")
-			data.Add("content", content)
-
-			u, _ := url.ParseRequestURI(val.WebhookURL)
-			urlStr := u.String()
-
-			client := &http.Client{}
-			r, err = http.NewRequest("POST", urlStr, strings.NewReader(data.Encode())) // URL-encoded payload
-			if err != nil {
-				fmt.Fprintln(w, "Error constructing the POST request, ", err)
-			}
-
-			// Specifying the request header parameters to send the data as JSON
-			r.Header.Add("Authorization", "auth_token=\"XXXXXXX\"")
-			r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-			r.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
-
-			resp, err := client.Do(r)
+			err = postToWebhook(val.WebhookURL, "tracks", content)
 			if err != nil {
 				fmt.Fprintln(w, "Error executing the POST request, ", err)
 			}
 
-			defer resp.Body.Close()
-
 		}
 
 		latestTrackCounter = currentTrackCount
@@ -456,9 +366,13 @@ func adminAPITracksCount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := mongoConnect()
+	count, err := tracksDB.CountTracks(r.Context())
+	if err != nil {
+		http.Error(w, "500 - Could not count the tracks", http.StatusInternalServerError)
+		return
+	}
 
-	fmt.Fprintf(w, "Current count of the tracks in DB is: %d", countAllTracks(client))
+	fmt.Fprintf(w, "Current count of the tracks in DB is: %d", count)
 }
 
 // Handles path: DELETE /admin/api/track
@@ -472,14 +386,32 @@ func adminAPITracks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	client := mongoConnect()
+	// Deleting all the track in DB
+	count, err := tracksDB.DeleteAllTracks(r.Context())
+	if err != nil {
+		http.Error(w, "500 - Could not delete the tracks", http.StatusInternalServerError)
+		return
+	}
 
-	// Notifying the admin first for the current count of the track
-	fmt.Fprintf(w, "Count of the tracks removed from DB is: %d", countAllTracks(client))
+	// Notifying the admin for the count of the tracks removed
+	fmt.Fprintf(w, "Count of the tracks removed from DB is: %d", count)
 
-	// Deleting all the track in DB
-	deleteAllTracks(client)
+}
 
+// Wraps an admin handler so it asks for the admin credentials, when they are configured
+func adminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.Admin.Username != "" {
+			user, password, ok := r.BasicAuth()
+			if !ok || subtle.ConstantTimeCompare([]byte(user), []byte(config.Admin.Username)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(password), []byte(config.Admin.Password)) != 1 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="paragliding admin"`)
+				http.Error(w, "401 - Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		handler(w, r)
+	}
 }
 
 func adminAPIWebhookTrigger(w http.ResponseWriter, r *http.Request) {
@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -172,3 +174,29 @@ func Test_webhookID(t *testing.T) {
 	}
 
 }
+
+func Test_waitForWebhooks(t *testing.T) {
+	received := make(chan string, 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		received <- r.PostForm.Get("content")
+	}))
+	defer ts.Close()
+
+	deliverWebhook(ts.URL, "TrackAdded", "new tracks")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := waitForWebhooks(ctx); err != nil {
+		t.Fatalf("Expected the delivery to finish, %s", err)
+	}
+
+	select {
+	case content := <-received:
+		assert.Equal(t, "new tracks", content)
+	default:
+		t.Error("The webhook was not invoked")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// *** XLSX EXPORT *** //
+
+// The parts of the smallest workbook the spreadsheets open, with a single sheet
+var xlsxParts = []struct{ Name, Content string }{
+	{"[Content_Types].xml", `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Tracks" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`</Relationships>`},
+}
+
+// Returns the name of the column in the spreadsheets, eg. A for 0, AA for 26
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// Writes a cell of the sheet, the numbers as numbers and the rest as inline strings, an empty value is no cell
+func writeXLSXCell(sheet *bytes.Buffer, ref, value string, numeric bool) {
+	if value == "" {
+		return
+	}
+	if numeric {
+		sheet.WriteString(`<c r="` + ref + `"><v>`)
+		xml.EscapeText(sheet, []byte(value))
+		sheet.WriteString(`</v></c>`)
+		return
+	}
+	sheet.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+	xml.EscapeText(sheet, []byte(value))
+	sheet.WriteString(`</t></is></c>`)
+}
+
+// Writes the track table as an Office Open XML workbook, with the names of the columns on the first row
+func writeTableXLSX(w io.Writer, table trackTable) error {
+	var sheet bytes.Buffer
+	sheet.WriteString(xml.Header + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for r, row := range append([][]string{table.Columns}, table.Rows...) {
+		number := strconv.Itoa(r + 1)
+		sheet.WriteString(`<row r="` + number + `">`)
+		for i, value := range row {
+			writeXLSXCell(&sheet, xlsxColumn(i)+number, value, r > 0 && table.Numeric[i])
+		}
+		sheet.WriteString(`</row>`)
+	}
+	sheet.WriteString(`</sheetData></worksheet>`)
+
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		file, err := archive.Create(part.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, xml.Header+part.Content); err != nil {
+			return err
+		}
+	}
+	file, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(sheet.Bytes()); err != nil {
+		return err
+	}
+	return archive.Close()
+}